@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/payment"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runAggregators handles "aggregators list"
+func runAggregators(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return usageError("aggregators")
+	}
+	flags := flag.NewFlagSet("aggregators list", flag.ContinueOnError)
+	format := outputFlag(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *format == "table" {
+		printAggregators(os.Stdout)
+		return nil
+	}
+
+	listing := map[string]interface{}{"adapters": payment.Registered()}
+	if cfg, err := config.Load(); err == nil {
+		instances := []payment.InstanceConfig{}
+		for _, name := range sortedKeys(cfg.Instances) {
+			instances = append(instances, cfg.Instances[name])
+		}
+		listing["instances"] = instances
+	}
+	return printOutput(os.Stdout, *format, listing, nil)
+}
+
+// printAggregators lists every registered adapter with its capabilities and config,
+// followed by the configured instances when the config loads
+func printAggregators(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDISPLAY NAME\tCAPABILITIES\tCURRENCIES")
+	for _, d := range payment.Registered() {
+		capabilities := make([]string, len(d.Capabilities))
+		for i, c := range d.Capabilities {
+			capabilities[i] = string(c)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, d.DisplayName, strings.Join(capabilities, ","), strings.Join(d.Currencies, ","))
+	}
+	w.Flush()
+
+	for _, d := range payment.Registered() {
+		fmt.Fprintf(out, "\n%s config (each key prefixed with the instance prefix, e.g. %s_):\n", d.Name, strings.ToUpper(d.Name))
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, field := range d.Config {
+			required := ""
+			if field.Required {
+				required = "required"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", field.Name, required, field.Default, field.Description)
+		}
+		w.Flush()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(out, "\ninstances: %v\n", err)
+		return
+	}
+	fmt.Fprintln(out, "\ninstances:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tTYPE\tPREFIX")
+	for _, name := range sortedKeys(cfg.Instances) {
+		instance := cfg.Instances[name]
+		fmt.Fprintf(w, "  %s\t%s\t%s_\n", instance.Name, instance.Type, instance.Prefix)
+	}
+	w.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
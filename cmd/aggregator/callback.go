@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/callback"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runCallback handles "callback list" and "callback replay", which processes stored
+// callbacks again through the same path as the /callback route
+func runCallback(args []string) error {
+	if len(args) == 0 {
+		return usageError("callback")
+	}
+	switch args[0] {
+	case "list":
+		return listCallbacks(args[1:])
+	case "replay":
+		return replayCallbacks(args[1:])
+	default:
+		return usageError("callback")
+	}
+}
+
+func listCallbacks(args []string) error {
+	flags := flag.NewFlagSet("callback list", flag.ContinueOnError)
+	results := flags.String("result", "", "only callbacks with these results, comma separated (e.g. error,unparsable)")
+	paymentID := flags.String("payment", "", "only callbacks linked to this payment")
+	limit := flags.Int64("limit", 50, "maximum number of callbacks, oldest first")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := database.CallbackFilter{Limit: *limit}
+	if *results != "" {
+		filter.Results = strings.Split(*results, ",")
+	}
+	if *paymentID != "" {
+		id, err := primitive.ObjectIDFromHex(*paymentID)
+		if err != nil {
+			return fmt.Errorf("invalid --payment: %w", err)
+		}
+		filter.PaymentID = id
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	callbacks, err := a.db.ListCallbacks(filter)
+	if err != nil {
+		return fmt.Errorf("failed to list callbacks: %w", err)
+	}
+	return printCallbacks(*format, callbacks)
+}
+
+func replayCallbacks(args []string) error {
+	flags := flag.NewFlagSet("callback replay", flag.ContinueOnError)
+	failed := flags.Bool("failed", false, "replay every callback whose processing failed")
+	limit := flags.Int64("limit", 100, "maximum number of failed callbacks to replay")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *failed == (flags.NArg() == 1) || flags.NArg() > 1 {
+		return usageError("callback")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *failed {
+		replayed, err := callback.ReplayFailed(context.Background(), a.db, a.cfg, *limit)
+		if err != nil {
+			return fmt.Errorf("failed to replay callbacks: %w", err)
+		}
+		return printCallbacks(*format, replayed)
+	}
+
+	id, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid callback ID: %w", err)
+	}
+	stored, outcome, err := callback.Replay(context.Background(), a.db, a.cfg, id)
+	if err != nil {
+		return fmt.Errorf("failed to replay callback %s: %w", id.Hex(), err)
+	}
+	if err := printCallbacks(*format, []models.CallbackModel{stored}); err != nil {
+		return err
+	}
+	if outcome.Result != callback.ResultApplied && outcome.Result != callback.ResultIgnored {
+		return fmt.Errorf("callback not applied: %v", outcome.Err)
+	}
+	return nil
+}
+
+func printCallbacks(format string, callbacks []models.CallbackModel) error {
+	return printOutput(os.Stdout, format, callbacks, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tRECEIVED\tAGGREGATOR\tVERIFICATION\tRESULT\tTRANSACTION\tSTATUS\tPAYMENT\tREPLAYS\tERROR")
+		for _, c := range callbacks {
+			paymentID := "-"
+			if !c.PaymentID.IsZero() {
+				paymentID = c.PaymentID.Hex()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				c.ID.Hex(), formatTime(c.ReceivedAt), c.Aggregator, c.Verification, c.Result, c.TransactionID, c.Status, paymentID, c.Replays, c.Error)
+		}
+	})
+}
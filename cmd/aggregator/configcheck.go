@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/config"
+	"time"
+)
+
+// configCheck is one line of "config check"
+type configCheck struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// runConfig handles "config check": it loads the config the way serve does,
+// reports missing instance settings and routes, and pings MongoDB
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return usageError("config")
+	}
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	format := outputFlag(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	checks := []configCheck{}
+	add := func(check string, err error) {
+		c := configCheck{Check: check, OK: err == nil}
+		if err != nil {
+			c.Detail = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	cfg, err := config.Load()
+	add("config loads", err)
+	if err == nil {
+		problems := cfg.Check()
+		for _, problem := range problems {
+			add("instance settings", problem)
+		}
+		if len(problems) == 0 {
+			add("instance settings", nil)
+		}
+		_, err = cfg.Route("deposit")
+		add("deposit route", err)
+	}
+
+	db, err := connectDatabase()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.Ping(ctx)
+		cancel()
+		db.Close()
+	}
+	add("mongo", err)
+
+	if err := printOutput(os.Stdout, *format, checks, func(w io.Writer) {
+		fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+		for _, c := range checks {
+			result := "ok"
+			if !c.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Check, result, c.Detail)
+		}
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range checks {
+		if !c.OK {
+			return fmt.Errorf("config check failed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/deposit"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/notify"
+	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/internal/tracing"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runDeposit makes one deposit for a merchant, asking for the account on the terminal
+// unless --bank-id is given, then notifies the merchant
+func runDeposit(args []string) error {
+	flags := flag.NewFlagSet("deposit", flag.ContinueOnError)
+	amount := flags.Float64("amount", 0, "amount to deposit")
+	aggregator := flags.String("aggregator", "", "aggregator instance to use, the deposit route's failover chain if empty")
+	merchantID := flags.String("merchant", os.Getenv("MERCHANT_ID"), "merchant the deposit is made for, MERCHANT_ID by default")
+	bankID := flags.String("bank-id", "", "provider account to deposit to, asked on the terminal if empty")
+	payerName := flags.String("payer-name", "", "who will send the money, checked against the account holder")
+	payerIBAN := flags.String("payer-iban", "", "the IBAN they send it from, counted towards IBAN limits")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *amount <= 0 {
+		return fmt.Errorf("--amount must be positive")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	merchant, err := findMerchant(a, *merchantID)
+	if err != nil {
+		return err
+	}
+
+	var flow payment.FlowRunner
+	if *aggregator != "" {
+		if !merchant.AllowsAggregator(*aggregator) {
+			return fmt.Errorf("merchant %s may not use aggregator %s", merchant.Name, *aggregator)
+		}
+		flow, err = factory.FlowRunnerForChain(a.cfg, "deposit", []string{*aggregator})
+	} else {
+		flow, err = factory.FlowRunnerForRoute(a.cfg, "deposit")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get flow runner: %w", err)
+	}
+
+	deposits, err := deposit.NewService(a.db, a.cfg, flow)
+	if err != nil {
+		return fmt.Errorf("failed to set up deposits: %w", err)
+	}
+	a.lifecycle.OnShutdown("deposit flows", shutdown.OrderFlows, deposits.Shutdown)
+
+	var selectAccount payment.AccountSelector
+	if *bankID != "" {
+		selectAccount = payment.SelectByID(*bankID)
+	}
+
+	ctx, span := tracing.Start(context.Background(), "CLIDeposit")
+	defer span.End()
+	response, paymentDoc, err := deposits.Deposit(ctx, merchant, *amount, deposit.Payer{Name: *payerName, IBAN: *payerIBAN}, selectAccount)
+	if err != nil {
+		return fmt.Errorf("deposit failed: %w (attempts: %+v)", err, paymentDoc.Attempts)
+	}
+	logger.InfoLogger.Printf("Deposit succeeded: %+v", response)
+
+	// Notify the merchant, close waits for it
+	var deliveries shutdown.Group
+	a.lifecycle.OnShutdown("merchant notifications", shutdown.OrderWorkers, deliveries.Drain)
+	if err := deliveries.Go(func() { notify.Merchant(ctx, merchant, paymentDoc) }); err != nil {
+		logger.WarningLogger.Printf("Merchant notification for %s not sent: %v", paymentDoc.TransactionID, err)
+	}
+
+	return printOutput(os.Stdout, *format, paymentDoc, func(w io.Writer) {
+		printPaymentTable(w, paymentDoc)
+	})
+}
+
+// runWithdraw is wired up for the withdrawal flow, which no adapter implements yet,
+// so it says which part is missing rather than pretending to withdraw
+func runWithdraw(args []string) error {
+	flags := flag.NewFlagSet("withdraw", flag.ContinueOnError)
+	amount := flags.Float64("amount", 0, "amount to withdraw")
+	aggregator := flags.String("aggregator", "", "aggregator instance to use")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *amount <= 0 {
+		return fmt.Errorf("--amount must be positive")
+	}
+
+	for _, d := range payment.Registered() {
+		if d.Supports(payment.CapabilityWithdrawal) && (*aggregator == "" || *aggregator == d.Name) {
+			return fmt.Errorf("withdrawal flows are not implemented yet")
+		}
+	}
+	return fmt.Errorf("withdrawals are not available: no adapter supports withdrawals")
+}
+
+// findMerchant loads the merchant a command acts for
+func findMerchant(a *app, merchantID string) (models.MerchantModel, error) {
+	if merchantID == "" {
+		return models.MerchantModel{}, fmt.Errorf("--merchant or MERCHANT_ID is required")
+	}
+	id, err := primitive.ObjectIDFromHex(merchantID)
+	if err != nil {
+		return models.MerchantModel{}, fmt.Errorf("invalid merchant ID: %w", err)
+	}
+	merchant, err := a.db.FindMerchant(id)
+	if err != nil {
+		return models.MerchantModel{}, fmt.Errorf("failed to load merchant %s: %w", merchantID, err)
+	}
+	return merchant, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/screening"
+	"payment-aggregator/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runLists handles "lists list", "lists add" and "lists remove ID", the IBAN, payer
+// and bank blocklist and allowlist
+func runLists(args []string) error {
+	if len(args) == 0 {
+		return usageError("lists")
+	}
+	switch args[0] {
+	case "list":
+		return listListEntries(args[1:])
+	case "add":
+		return addListEntry(args[1:])
+	case "remove":
+		return removeListEntry(args[1:])
+	default:
+		return usageError("lists")
+	}
+}
+
+func listListEntries(args []string) error {
+	flags := flag.NewFlagSet("lists list", flag.ContinueOnError)
+	list := flags.String("list", "", "only this list, block or allow")
+	kind := flags.String("kind", "", "only entries of this kind, iban, payer or bank")
+	inactive := flags.Bool("inactive", false, "include expired and removed entries")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	entries, err := a.db.ListEntries(database.ListEntryFilter{List: *list, Kind: *kind, Inactive: *inactive})
+	if err != nil {
+		return fmt.Errorf("failed to list entries: %w", err)
+	}
+	return printListEntries(*format, entries)
+}
+
+func addListEntry(args []string) error {
+	flags := flag.NewFlagSet("lists add", flag.ContinueOnError)
+	list := flags.String("list", models.ListBlock, "block or allow")
+	kind := flags.String("kind", "", "iban, payer or bank")
+	value := flags.String("value", "", "the IBAN, payer name or bank name")
+	reason := flags.String("reason", "", "why, required")
+	expires := flags.String("expires", "", "when the entry stops applying, YYYY-MM-DD or a number of days like 30d; never if empty")
+	createdBy := flags.String("by", currentUser(), "who asked for the entry")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	expiresAt, err := parseExpiry(*expires)
+	if err != nil {
+		return fmt.Errorf("invalid --expires: %w", err)
+	}
+	entry, err := screening.NewEntry(*list, *kind, *value, *reason, *createdBy, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if err := a.db.InsertListEntry(&entry); err != nil {
+		return fmt.Errorf("failed to add entry: %w", err)
+	}
+	return printListEntries(*format, []models.ListEntryModel{entry})
+}
+
+func removeListEntry(args []string) error {
+	flags := flag.NewFlagSet("lists remove", flag.ContinueOnError)
+	removedBy := flags.String("by", currentUser(), "who removed the entry")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("lists")
+	}
+	id, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid entry ID: %w", err)
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	entry, err := a.db.RemoveListEntry(id, *removedBy)
+	if err != nil {
+		return fmt.Errorf("failed to remove entry %s: %w", id.Hex(), err)
+	}
+	return printListEntries(*format, []models.ListEntryModel{entry})
+}
+
+// parseExpiry reads a date in the local timezone (the entry expires at its end) or a number of days from now
+func parseExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return time.Time{}, fmt.Errorf("expected a positive number of days, got %q", value)
+		}
+		return time.Now().AddDate(0, 0, n), nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+func printListEntries(format string, entries []models.ListEntryModel) error {
+	return printOutput(os.Stdout, format, entries, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tLIST\tKIND\tVALUE\tREASON\tBY\tCREATED\tEXPIRES\tREMOVED")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.ID.Hex(), e.List, e.Kind, e.Value, e.Reason, e.CreatedBy, formatTime(e.CreatedAt), formatTime(e.ExpiresAt), formatTime(e.RemovedAt))
+		}
+	})
+}
@@ -1,20 +1,52 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"payment-aggregator/internal/audit"
+	"payment-aggregator/internal/config"
 	"payment-aggregator/internal/database"
-	"payment-aggregator/internal/factory"
 	"payment-aggregator/internal/logger"
 	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/internal/tracing"
+	"sort"
 
 	"github.com/joho/godotenv"
 )
 
+// command is one operator subcommand, run with the arguments after its name
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// filled in init, the commands print their own usage from it
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":       {"serve", runServe},
+		"deposit":     {"deposit --amount AMOUNT [--aggregator NAME] [--merchant ID] [--bank-id ID] [--payer-name NAME] [--payer-iban IBAN] [-o json|table]", runDeposit},
+		"withdraw":    {"withdraw --amount AMOUNT [--aggregator NAME]", runWithdraw},
+		"payments":    {"payments list [--merchant ID] [--status S] [--aggregator NAME] [--limit N] [-o json|table] | payments show [-o json|table] ID | payments reopen [--window D] [--by NAME] [-o json|table] ID", runPayments},
+		"callback":    {"callback list [--result R,...] [--payment ID] [--limit N] [-o json|table] | callback replay [-o json|table] ID | callback replay --failed [--limit N] [-o json|table]", runCallback},
+		"config":      {"config check [-o json|table]", runConfig},
+		"aggregators": {"aggregators list [-o json|table]", runAggregators},
+		"lists":       {"lists list [--list block|allow] [--kind K] [--inactive] [-o json|table] | lists add --list block|allow --kind iban|payer|bank --value V --reason R [--expires YYYY-MM-DD|Nd] [--by NAME] | lists remove [--by NAME] ID", runLists},
+		"review":      {"review list [--limit N] [-o json|table] | review approve|reject [--note TEXT] [--reviewer NAME] [-o json|table] ID", runReview},
+		"migrate":     {"migrate up [--to VERSION] [-o json|table] | migrate down [--steps N] [-o json|table] | migrate status [-o json|table]", runMigrate},
+		"report":      {"report settlement [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--merchant ID] [--format csv|xlsx] [--out FILE]", runReport},
+	}
+}
+
+// usageError is returned when a command is called with the wrong arguments
+func usageError(name string) error {
+	return fmt.Errorf("usage: aggregator %s", commands[name].usage)
+}
+
 func main() {
 	// Initialize logger
 	logger.InitLogger()
@@ -29,70 +61,96 @@ func main() {
 		log.Println(".env file loaded successfully.")
 	}
 
-	// Initialize MongoDB connection
-	databaseURI := os.Getenv("DATABASE_PROTOCOL") + os.Getenv("DATABASE_BASE") + ":" + os.Getenv("DATABASE_PORT") + "/" + os.Getenv("DATABASE_NAME")
+	// Without a command the binary serves, as it always did
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(os.Stdout)
+		return
+	}
 
-	logger.InfoLogger.Println("Connecting to MongoDB at:", databaseURI)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	db, err := database.NewDatabase(databaseURI, os.Getenv("DATABASE_NAME"), "Payments")
-	if err != nil {
-		logger.ErrorLogger.Fatalf("Failed to connect to MongoDB: %v", err)
+func printUsage(out *os.File) {
+	fmt.Fprintln(out, "usage: aggregator <command> [arguments]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
 	}
-	defer db.Close()
+}
+
+// app is what the commands share: the config, the repository and the shutdown hooks
+type app struct {
+	cfg       *config.Config
+	db        *database.Database
+	lifecycle *shutdown.Manager
+}
 
-	// Start the server to handle callbacks
-	go database.StartServer(db)
+// newApp loads the config, sets up tracing and connects to MongoDB.
+// Commands other than serve call close when done
+func newApp() (*app, error) {
+	// Hooks run in order on SIGINT/SIGTERM or close, registered as components start
+	lifecycle := shutdown.NewManager(shutdown.TimeoutFromEnv())
 
-	// Start the flow
-	flow, err := factory.FlowRunnerFromEnv()
+	// Load aggregator instances and routes
+	cfg, err := config.Load()
 	if err != nil {
-		logger.ErrorLogger.Fatalf("Failed to get flow runner: %v", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Make a deposit flow
-	response, responseModel, err := flow.RunDepositFlow(100.0)
+	// Export traces when TRACING_EXPORTER is set
+	stopTracing, err := tracing.Setup(tracing.SettingsFromEnv())
 	if err != nil {
-		logger.ErrorLogger.Printf("Deposit failed: %v", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
 	}
+	lifecycle.OnShutdown("tracing", shutdown.OrderTelemetry, stopTracing)
 
-	// Log if the deposit was successful
-	logger.InfoLogger.Printf("Deposit succeeded: %+v", response)
+	db, err := connectDatabase()
+	if err != nil {
+		lifecycle.Shutdown()
+		return nil, err
+	}
+	lifecycle.OnShutdown("mongo", shutdown.OrderDatabase, func(context.Context) error {
+		return db.Close()
+	})
+
+	// Provider calls are recorded in the audit trail
+	recorder := audit.Start(db, database.AuditRetentionFromEnv())
+	lifecycle.OnShutdown("audit trail", shutdown.OrderWorkers, recorder.Close)
+
+	return &app{cfg: cfg, db: db, lifecycle: lifecycle}, nil
+}
 
-	// Insert payment into MongoDB
-	err = db.InsertPayment(responseModel)
+// connectDatabase opens the MongoDB connection from the DATABASE_* variables
+func connectDatabase() (*database.Database, error) {
+	databaseURI := os.Getenv("DATABASE_PROTOCOL") + os.Getenv("DATABASE_BASE") + ":" + os.Getenv("DATABASE_PORT") + "/" + os.Getenv("DATABASE_NAME")
+
+	logger.InfoLogger.Println("Connecting to MongoDB at:", databaseURI)
+
+	db, err := database.NewDatabase(databaseURI, os.Getenv("DATABASE_NAME"), "Payments")
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to insert payment: %v", err)
-		os.Exit(1)
-	} else {
-		logger.InfoLogger.Println("Payment inserted successfully.")
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
+	return db, nil
+}
 
-	// HTTP server to handle callbacks
-	go func() {
-		callbackURL := os.Getenv("CALLBACK_URL")
-
-		// Encode responseModel into JSON
-		var buf bytes.Buffer
-		err := json.NewEncoder(&buf).Encode(responseModel)
-		if err != nil {
-			logger.ErrorLogger.Printf("Failed to encode callback payload: %v", err)
-			return
-		}
-
-		// Send the POST request
-		resp, err := http.Post(callbackURL, "application/json", &buf)
-		if err != nil {
-			logger.ErrorLogger.Printf("Failed to GET callback: %v", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		logger.InfoLogger.Printf("Callback GET to %s, response: %s", callbackURL, resp.Status)
-	}()
-
-	// TODO: Make a withdrawal flow
-
-	// Shutdown
-	shutdown.WaitForShutdown(db)
+// close runs the shutdown hooks of a short-lived command
+func (a *app) close() {
+	a.lifecycle.Shutdown()
 }
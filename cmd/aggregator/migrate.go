@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+)
+
+// runMigrate handles "migrate up", "migrate down" and "migrate status"
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return usageError("migrate")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	format := outputFlag(flags)
+
+	var run func(a *app) ([]database.MigrationStatus, error)
+	switch args[0] {
+	case "up":
+		version := flags.Int("to", 0, "apply migrations up to and including this version, all if 0")
+		run = func(a *app) ([]database.MigrationStatus, error) {
+			return a.db.MigrateUp(context.Background(), *version)
+		}
+	case "down":
+		steps := flags.Int("steps", 1, "number of applied migrations to undo, newest first")
+		run = func(a *app) ([]database.MigrationStatus, error) {
+			return a.db.MigrateDown(context.Background(), *steps)
+		}
+	case "status":
+		run = func(a *app) ([]database.MigrationStatus, error) { return a.db.Migrations(context.Background()) }
+	default:
+		return usageError("migrate")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError("migrate")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	statuses, err := run(a)
+	if err != nil {
+		// the ones that ran before the failure are still worth showing
+		printMigrations(*format, statuses)
+		return err
+	}
+	if args[0] != "status" && len(statuses) == 0 {
+		logger.InfoLogger.Println("Migrations: nothing to do")
+	}
+	return printMigrations(*format, statuses)
+}
+
+// migrateOnStartup applies pending migrations before serving, unless MIGRATE_ON_STARTUP=false
+func migrateOnStartup(db *database.Database) error {
+	if os.Getenv("MIGRATE_ON_STARTUP") == "false" {
+		return nil
+	}
+	applied, err := db.MigrateUp(context.Background(), 0)
+	for _, m := range applied {
+		logger.InfoLogger.Printf("Migrations: applied %d (%s)", m.Version, m.Name)
+	}
+	return err
+}
+
+func printMigrations(format string, statuses []database.MigrationStatus) error {
+	return printOutput(os.Stdout, format, statuses, func(w io.Writer) {
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range statuses {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, formatTime(m.AppliedAt))
+		}
+	})
+}
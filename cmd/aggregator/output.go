@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// outputFlag adds -o/--output to a command's flags
+func outputFlag(flags *flag.FlagSet) *string {
+	format := flags.String("output", "table", "json or table")
+	flags.StringVar(format, "o", "table", "shorthand for --output")
+	return format
+}
+
+// printOutput writes v as indented JSON, or calls table with a tabwriter
+func printOutput(out io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "table":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q, use json or table", format)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/expiry"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runPayments handles "payments list", "payments show ID" and "payments reopen ID"
+func runPayments(args []string) error {
+	if len(args) == 0 {
+		return usageError("payments")
+	}
+	switch args[0] {
+	case "list":
+		return listPayments(args[1:])
+	case "show":
+		return showPayment(args[1:])
+	case "reopen":
+		return reopenPayment(args[1:])
+	default:
+		return usageError("payments")
+	}
+}
+
+func listPayments(args []string) error {
+	flags := flag.NewFlagSet("payments list", flag.ContinueOnError)
+	merchant := flags.String("merchant", "", "only this merchant's payments")
+	status := flags.String("status", "", "only payments in this status")
+	aggregator := flags.String("aggregator", "", "only payments made through this aggregator instance")
+	limit := flags.Int64("limit", 50, "maximum number of payments, newest first")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := database.PaymentFilter{Status: *status, Aggregator: *aggregator, Limit: *limit}
+	if *merchant != "" {
+		id, err := primitive.ObjectIDFromHex(*merchant)
+		if err != nil {
+			return fmt.Errorf("invalid --merchant: %w", err)
+		}
+		filter.MerchantID = id
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	payments, err := a.db.FindPayments(filter)
+	if err != nil {
+		return fmt.Errorf("failed to list payments: %w", err)
+	}
+
+	return printOutput(os.Stdout, *format, payments, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCREATED\tTYPE\tSTATUS\tAMOUNT\tAGGREGATOR\tTRANSACTION\tMERCHANT")
+		for _, p := range payments {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f\t%s\t%s\t%s\n",
+				p.ID.Hex(), formatTime(p.CreatedAt), p.TransactionType, p.Status, p.Amount, p.Aggregator, p.TransactionID, p.MerchantID.Hex())
+		}
+	})
+}
+
+func showPayment(args []string) error {
+	flags := flag.NewFlagSet("payments show", flag.ContinueOnError)
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: aggregator payments show [-o json|table] ID")
+	}
+	id, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid payment ID: %w", err)
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	paymentDoc, err := a.db.FindPaymentByID(id)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("payment %s not found", id.Hex())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find payment: %w", err)
+	}
+
+	return printOutput(os.Stdout, *format, paymentDoc, func(w io.Writer) {
+		printPaymentTable(w, paymentDoc)
+	})
+}
+
+// reopenPayment moves an expired deposit back to pending and replays its late callbacks
+func reopenPayment(args []string) error {
+	flags := flag.NewFlagSet("payments reopen", flag.ContinueOnError)
+	window := flags.Duration("window", 0, "how long the deposit may stay pending again, the aggregator's expiry window if 0")
+	by := flags.String("by", currentUser(), "who re-opened the payment")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("payments")
+	}
+	id, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid payment ID: %w", err)
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	paymentDoc, replayed, err := expiry.Reopen(context.Background(), a.db, a.cfg, id, *by, *window)
+	if err != nil {
+		return fmt.Errorf("failed to re-open payment %s: %w", id.Hex(), err)
+	}
+	logger.InfoLogger.Printf("Payment %s re-opened, %d late callbacks replayed", id.Hex(), len(replayed))
+	return printOutput(os.Stdout, *format, paymentDoc, func(w io.Writer) {
+		printPaymentTable(w, paymentDoc)
+	})
+}
+
+// printPaymentTable prints one payment as field/value rows
+func printPaymentTable(w io.Writer, p models.PaymentModel) {
+	fmt.Fprintf(w, "ID\t%s\n", p.ID.Hex())
+	fmt.Fprintf(w, "Merchant\t%s\n", p.MerchantID.Hex())
+	fmt.Fprintf(w, "Type\t%s\n", p.TransactionType)
+	fmt.Fprintf(w, "Status\t%s\n", p.Status)
+	fmt.Fprintf(w, "Amount\t%.2f\n", p.Amount)
+	if p.Fees != nil {
+		fmt.Fprintf(w, "Fees\tprovider %.2f, ours %.2f, net %.2f\n", p.Fees.ProviderFee, p.Fees.Fee, p.Fees.Net)
+	}
+	if p.RefundedAmount > 0 {
+		fmt.Fprintf(w, "Refunded\t%.2f\n", p.RefundedAmount)
+	}
+	if !p.OriginalPaymentID.IsZero() {
+		fmt.Fprintf(w, "Refund of\t%s\n", p.OriginalPaymentID.Hex())
+	}
+	fmt.Fprintf(w, "Aggregator\t%s\n", p.Aggregator)
+	fmt.Fprintf(w, "Transaction\t%s\n", p.TransactionID)
+	fmt.Fprintf(w, "Payer\t%s\n", p.PayerName)
+	fmt.Fprintf(w, "IBAN\t%s\n", p.IBAN)
+	fmt.Fprintf(w, "Bank\t%s\n", p.BankName)
+	if p.Risk != nil {
+		fmt.Fprintf(w, "Risk\t%s, score %d\n", p.Risk.Decision, p.Risk.Score)
+		for _, hit := range p.Risk.Hits {
+			fmt.Fprintf(w, "Risk rule\t%s +%d %s\n", hit.Rule, hit.Score, hit.Reason)
+		}
+		if p.Risk.Review != nil {
+			fmt.Fprintf(w, "Review\t%s by %s %s %s\n", p.Risk.Review.Outcome, p.Risk.Review.Reviewer, formatTime(p.Risk.Review.ReviewedAt), p.Risk.Review.Note)
+		}
+	}
+	fmt.Fprintf(w, "Created\t%s\n", formatTime(p.CreatedAt))
+	if p.ExpiresAt != 0 {
+		fmt.Fprintf(w, "Expires\t%s\n", formatTime(p.ExpiresAt))
+	}
+	fmt.Fprintf(w, "Updated\t%s\n", formatTime(p.UpdatedAt))
+	for _, attempt := range p.Attempts {
+		fmt.Fprintf(w, "Attempt\t%s %s %s %s\n", attempt.Aggregator, attempt.Outcome, attempt.Stage, attempt.Reason)
+	}
+}
+
+func formatTime(t primitive.DateTime) string {
+	if t == 0 {
+		return "-"
+	}
+	return t.Time().Local().Format(time.DateTime)
+}
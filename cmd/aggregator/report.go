@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment-aggregator/internal/report"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runReport handles "report settlement [--from DAY] [--to DAY] [--merchant ID] [--format csv|xlsx] [--out FILE]"
+func runReport(args []string) error {
+	if len(args) == 0 || args[0] != "settlement" {
+		return usageError("report")
+	}
+
+	loc, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		return err
+	}
+	yesterday := time.Now().In(loc).AddDate(0, 0, -1).Format("2006-01-02")
+
+	flags := flag.NewFlagSet("report settlement", flag.ContinueOnError)
+	fromDay := flags.String("from", yesterday, "first business day")
+	toDay := flags.String("to", "", "last business day, defaults to --from")
+	merchant := flags.String("merchant", "", "only this merchant's payments")
+	format := flags.String("format", "csv", "csv or xlsx")
+	out := flags.String("out", "", "output file, stdout if empty")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *toDay == "" {
+		*toDay = *fromDay
+	}
+
+	from, err := report.ParseDay(*fromDay)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	to, err := report.ParseDay(*toDay)
+	if err != nil {
+		return fmt.Errorf("invalid --to: %w", err)
+	}
+
+	var merchantID primitive.ObjectID
+	if *merchant != "" {
+		if merchantID, err = primitive.ObjectIDFromHex(*merchant); err != nil {
+			return fmt.Errorf("invalid --merchant: %w", err)
+		}
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	settlement, err := report.Generate(a.db, from, to, merchantID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return settlement.Write(w, *format)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"payment-aggregator/internal/refund"
+	"payment-aggregator/internal/risk"
+	"payment-aggregator/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runReview handles "review list" and "review approve|reject ID", the risk review queue
+func runReview(args []string) error {
+	if len(args) == 0 {
+		return usageError("review")
+	}
+	switch args[0] {
+	case "list":
+		return listReviews(args[1:])
+	case "approve":
+		return decideReview(models.ReviewApproved, args[1:])
+	case "reject":
+		return decideReview(models.ReviewRejected, args[1:])
+	default:
+		return usageError("review")
+	}
+}
+
+func listReviews(args []string) error {
+	flags := flag.NewFlagSet("review list", flag.ContinueOnError)
+	limit := flags.Int64("limit", 50, "maximum number of payments, oldest first")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	payments, err := a.db.ListPaymentsForReview(*limit)
+	if err != nil {
+		return fmt.Errorf("failed to list the review queue: %w", err)
+	}
+
+	return printOutput(os.Stdout, *format, payments, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCREATED\tSTATUS\tAMOUNT\tAGGREGATOR\tMERCHANT\tSCORE\tRULES")
+		for _, p := range payments {
+			rules := make([]string, 0, len(p.Risk.Hits))
+			for _, hit := range p.Risk.Hits {
+				rules = append(rules, hit.Rule)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%s\t%s\t%d\t%s\n",
+				p.ID.Hex(), formatTime(p.CreatedAt), p.Status, p.Amount, p.Aggregator, p.MerchantID.Hex(), p.Risk.Score, strings.Join(rules, ","))
+		}
+	})
+}
+
+func decideReview(outcome string, args []string) error {
+	flags := flag.NewFlagSet("review", flag.ContinueOnError)
+	note := flags.String("note", "", "why, kept with the review")
+	reviewer := flags.String("reviewer", currentUser(), "who reviewed the payment")
+	format := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("review")
+	}
+	id, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid payment ID: %w", err)
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	paymentDoc, err := risk.Review(a.db, refund.NewService(a.db, a.cfg), id, outcome, *reviewer, *note)
+	if err != nil {
+		return fmt.Errorf("failed to review payment %s: %w", id.Hex(), err)
+	}
+	return printOutput(os.Stdout, *format, paymentDoc, func(w io.Writer) {
+		printPaymentTable(w, paymentDoc)
+	})
+}
+
+// currentUser names the operator running the command
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "cli"
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"payment-aggregator/internal/deposit"
+	"payment-aggregator/internal/expiry"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/poller"
+	"payment-aggregator/internal/server"
+	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/internal/transition"
+)
+
+// runServe runs the HTTP server, the poller and the expiry sweeper until SIGINT/SIGTERM
+func runServe(args []string) error {
+	if len(args) > 0 {
+		return usageError("serve")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+
+	// Indexes and backfills the code relies on
+	if err := migrateOnStartup(a.db); err != nil {
+		a.close()
+		return fmt.Errorf("failed to migrate the database: %w", err)
+	}
+
+	// Start the flow
+	flow, err := factory.FlowRunnerForRoute(a.cfg, "deposit")
+	if err != nil {
+		a.close()
+		return fmt.Errorf("failed to get flow runner: %w", err)
+	}
+
+	// Deposits are stored with their fees
+	deposits, err := deposit.NewService(a.db, a.cfg, flow)
+	if err != nil {
+		a.close()
+		return fmt.Errorf("failed to set up deposits: %w", err)
+	}
+	a.lifecycle.OnShutdown("deposit flows", shutdown.OrderFlows, deposits.Shutdown)
+
+	// Start the server to handle callbacks and the merchant API
+	if err := server.StartServer(a.db, a.cfg, deposits, a.lifecycle); err != nil {
+		a.close()
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+
+	// Poll payments whose callbacks don't arrive, until shutdown starts
+	pollerDone := make(chan struct{})
+	go func() {
+		defer close(pollerDone)
+		poller.New(a.db, a.cfg, poller.SettingsFromEnv()).Run(a.lifecycle.Context())
+	}()
+	a.lifecycle.OnShutdown("poller", shutdown.OrderWorkers, func(ctx context.Context) error {
+		select {
+		case <-pollerDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// Expire deposits the payer never paid, until shutdown starts
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		expiry.NewSweeper(a.db, a.cfg).Run(a.lifecycle.Context())
+	}()
+	a.lifecycle.OnShutdown("expiry sweeper", shutdown.OrderWorkers, func(ctx context.Context) error {
+		select {
+		case <-sweeperDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// Retry the ledger postings of transitions interrupted by a crash or a database error
+	postingsDone := make(chan struct{})
+	go func() {
+		defer close(postingsDone)
+		transition.NewPostingWorker(a.db).Run(a.lifecycle.Context())
+	}()
+	a.lifecycle.OnShutdown("posting worker", shutdown.OrderWorkers, func(ctx context.Context) error {
+		select {
+		case <-postingsDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// TODO: Make a withdrawal flow
+
+	// Shutdown
+	return a.lifecycle.Wait()
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recorder writes audit records. Each one is written before the provider's response is
+// handed back, so a crash can't lose the record of a call that moved money
+type Recorder struct {
+	db     *database.Database
+	writes sync.WaitGroup // records being written
+}
+
+// the recorder the adapters' transports write to, none until Start is called
+var (
+	recorderMu sync.RWMutex
+	recorder   *Recorder
+)
+
+// Start makes the transports record to db. Records older than retention are removed by Mongo,
+// failing to set that up only loses the cleanup, not the records
+func Start(db *database.Database, retention time.Duration) *Recorder {
+	err := db.SetAuditRetention(retention)
+	if errors.Is(err, database.ErrNotFound) {
+		logger.InfoLogger.Printf("Audit: no retention index yet, the migrations create it")
+	} else if err != nil {
+		logger.ErrorLogger.Printf("Audit: failed to set retention to %s: %v", retention, err)
+	}
+
+	r := &Recorder{db: db}
+
+	recorderMu.Lock()
+	recorder = r
+	recorderMu.Unlock()
+	return r
+}
+
+func (r *Recorder) insert(record models.AuditModel) {
+	if err := r.db.InsertAudit(record); err != nil {
+		logger.ErrorLogger.Printf("Audit: failed to record %s %s: %v", record.Method, record.URL, err)
+	}
+}
+
+// Close stops recording and waits until the records being written are, or ctx is done
+func (r *Recorder) Close(ctx context.Context) error {
+	recorderMu.Lock()
+	if recorder == r {
+		recorder = nil
+	}
+	recorderMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// record writes a record, the provider call waits for it
+func record(rec models.AuditModel) {
+	recorderMu.RLock()
+	r := recorder
+	if r != nil {
+		r.writes.Add(1)
+	}
+	recorderMu.RUnlock()
+	if r == nil {
+		return
+	}
+
+	defer r.writes.Done()
+	r.insert(rec)
+}
+
+type contextKey int
+
+const (
+	paymentKey contextKey = iota
+	correlationKey
+)
+
+// WithPayment tags the provider calls made with ctx with the payment they are for
+func WithPayment(ctx context.Context, paymentID primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, paymentKey, paymentID)
+}
+
+// WithCorrelationID tags the provider calls made with ctx with a correlation ID,
+// also sent to the provider as X-Correlation-ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey, id)
+}
+
+// CorrelationID returns the correlation ID of ctx, if any
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey).(string)
+	return id
+}
+
+// NewCorrelationID uses the trace ID of ctx, so audit records and traces can be matched,
+// or a random ID when ctx isn't traced
+func NewCorrelationID(ctx context.Context) string {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		return spanContext.TraceID().String()
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func paymentID(ctx context.Context) primitive.ObjectID {
+	id, _ := ctx.Value(paymentKey).(primitive.ObjectID)
+	return id
+}
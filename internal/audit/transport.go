@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bodies are stored up to this size
+const maxBody = 64 << 10
+
+// headers and JSON fields whose values are never stored
+var (
+	secretHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	secretFields  = []string{"apikey", "api_key", "password", "secret", "token", "accesstoken", "access_token", "refreshtoken", "refresh_token"}
+)
+
+const redacted = "[redacted]"
+
+// Transport records every request it sends to an aggregator instance, and its response,
+// in the audit trail
+type Transport struct {
+	Aggregator string
+	Base       http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx := req.Context()
+	if id := CorrelationID(ctx); id != "" {
+		req = req.Clone(ctx)
+		req.Header.Set("X-Correlation-ID", id)
+	}
+
+	rec := models.AuditModel{
+		Aggregator:     t.Aggregator,
+		CorrelationID:  CorrelationID(ctx),
+		PaymentID:      paymentID(ctx),
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeaders: redactHeaders(req.Header),
+		SentAt:         primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			raw, _ := io.ReadAll(body)
+			body.Close()
+			rec.RequestBody = redactBody(raw)
+		}
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	rec.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		rec.Error = err.Error()
+		record(rec)
+		return resp, err
+	}
+
+	// read the body for the record and hand the caller an identical one
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	rec.StatusCode = resp.StatusCode
+	rec.ResponseHeaders = redactHeaders(resp.Header)
+	rec.ResponseBody = redactBody(raw)
+	if err != nil {
+		rec.Error = err.Error()
+		record(rec)
+		return nil, err
+	}
+	record(rec)
+
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	return resp, nil
+}
+
+func redactHeaders(headers http.Header) map[string][]string {
+	stored := headers.Clone()
+	for _, key := range secretHeaders {
+		if _, ok := stored[http.CanonicalHeaderKey(key)]; ok {
+			stored.Set(key, redacted)
+		}
+	}
+	return stored
+}
+
+// redactBody masks secret fields of JSON bodies and truncates large ones
+func redactBody(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err == nil {
+		if masked, err := json.Marshal(redactValue(v)); err == nil {
+			raw = masked
+		}
+	}
+	if len(raw) > maxBody {
+		return string(raw[:maxBody]) + "...[truncated]"
+	}
+	return string(raw)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretField(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretFields {
+		if key == secret {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const keyPrefix = "pa_"
+
+type contextKey struct{}
+
+// GenerateAPIKey returns a new plain API key and the record to store for it.
+// The plain key is only ever shown to the caller once
+func GenerateAPIKey() (string, models.APIKeyModel, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", models.APIKeyModel{}, err
+	}
+	plain := keyPrefix + hex.EncodeToString(secret)
+
+	return plain, models.APIKeyModel{
+		Prefix:    plain[:len(keyPrefix)+8],
+		Hash:      HashAPIKey(plain),
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}, nil
+}
+
+// HashAPIKey hashes a key for storage and lookup. Keys are random and long,
+// so a plain SHA-256 is enough and keeps lookups a single indexed query
+func HashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// MerchantFromContext returns the merchant authenticated by RequireMerchant
+func MerchantFromContext(ctx context.Context) (models.MerchantModel, bool) {
+	merchant, ok := ctx.Value(contextKey{}).(models.MerchantModel)
+	return merchant, ok
+}
+
+// WithMerchant returns a context carrying the merchant, for flows started outside HTTP
+func WithMerchant(ctx context.Context, merchant models.MerchantModel) context.Context {
+	return context.WithValue(ctx, contextKey{}, merchant)
+}
+
+// RequireMerchant authenticates requests with a merchant API key
+// sent as "Authorization: Bearer <key>" or "X-API-Key: <key>"
+func RequireMerchant(db *database.Database, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			http.Error(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		merchant, err := db.FindMerchantByAPIKeyHash(HashAPIKey(key))
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to look up API key: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if merchant.Status != models.MerchantActive {
+			http.Error(w, "merchant is not active", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithMerchant(r.Context(), merchant)))
+	})
+}
+
+// RequireAdmin authenticates operator requests with the ADMIN_API_KEY variable.
+// Admin routes are disabled entirely while it is unset
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		if adminKey == "" {
+			http.Error(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		key := apiKeyFromRequest(r)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			http.Error(w, "invalid admin key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScrapeToken authenticates the metrics scraper with the METRICS_TOKEN variable,
+// sent like an API key. Without it the metrics listener is open to whoever can reach it
+func RequireScrapeToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("METRICS_TOKEN")
+		if token != "" && subtle.ConstantTimeCompare([]byte(apiKeyFromRequest(r)), []byte(token)) != 1 {
+			http.Error(w, "invalid scrape token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/metrics"
+	"payment-aggregator/internal/tracing"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// results of processing a callback
+const (
+	ResultApplied            = "applied"
+	ResultIgnored            = "ignored" // valid, but the status rules don't allow the change
+	ResultLate               = "late"    // arrived after the payment expired, applied if an operator re-opens it
+	ResultUnknownTransaction = "unknown_transaction"
+	ResultUnparsable         = "unparsable"
+	ResultRejected           = "rejected"   // failed the adapter's verification
+	ResultUnverified         = "unverified" // the adapter can't verify it, kept but never applied
+	ResultError              = "error"
+)
+
+// FailedResults are the results worth replaying once the cause is fixed
+var FailedResults = []string{ResultUnknownTransaction, ResultUnparsable, ResultRejected, ResultError}
+
+// headers whose values are not stored
+var redactedHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// Outcome is what processing a callback did
+type Outcome struct {
+	Result  string
+	Update  payment.TransactionStatus
+	Payment models.PaymentModel
+	Err     error
+}
+
+// Receive stores a callback as received, then verifies and processes it and records the outcome.
+// A callback that can't be stored is still processed
+func Receive(ctx context.Context, db *database.Database, cfg *config.Config, received models.CallbackModel) (models.CallbackModel, Outcome) {
+	received.Headers = redact(received.Headers)
+	received.ReceivedAt = primitive.NewDateTimeFromTime(time.Now())
+	if err := db.InsertCallback(&received); err != nil {
+		logger.ErrorLogger.Printf("Failed to store callback: %v", err)
+	}
+
+	outcome := handle(ctx, db, cfg, &received, false)
+	return received, outcome
+}
+
+// Replay processes a stored callback again through the normal path
+func Replay(ctx context.Context, db *database.Database, cfg *config.Config, id primitive.ObjectID) (models.CallbackModel, Outcome, error) {
+	stored, err := db.FindCallback(id)
+	if err != nil {
+		return stored, Outcome{}, err
+	}
+	logger.InfoLogger.Printf("Replaying callback %s (last result %s)", id.Hex(), stored.Result)
+	outcome := handle(ctx, db, cfg, &stored, true)
+	return stored, outcome, nil
+}
+
+// ReplayFailed replays up to limit stored callbacks whose processing failed, oldest first
+func ReplayFailed(ctx context.Context, db *database.Database, cfg *config.Config, limit int64) ([]models.CallbackModel, error) {
+	failed, err := db.ListCallbacks(database.CallbackFilter{Results: FailedResults, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	for i := range failed {
+		handle(ctx, db, cfg, &failed[i], true)
+	}
+	return failed, nil
+}
+
+// handle verifies and processes a callback and records the outcome on it
+func handle(ctx context.Context, db *database.Database, cfg *config.Config, cb *models.CallbackModel, replay bool) Outcome {
+	var outcome Outcome
+	verification, err := verify(cfg, cb.Aggregator, cb.Headers, []byte(cb.Body))
+	cb.Verification = verification
+	switch {
+	case err != nil:
+		logger.WarningLogger.Printf("Callback failed verification: %v", err)
+		metrics.Callbacks.WithLabelValues(ResultRejected).Inc()
+		outcome = Outcome{Result: ResultRejected, Err: err}
+	case verification == models.CallbackUnverifiable:
+		// anyone can reach /callback, a status nobody vouches for must not move money
+		logger.WarningLogger.Printf("Callback for instance %q can't be verified, not applied", cb.Aggregator)
+		metrics.Callbacks.WithLabelValues(ResultUnverified).Inc()
+		outcome = Outcome{Result: ResultUnverified, Err: errUnverifiable}
+	default:
+		outcome = Process(ctx, db, cfg, cb.Aggregator, []byte(cb.Body))
+	}
+
+	cb.Result = outcome.Result
+	cb.Error = ""
+	if outcome.Err != nil {
+		cb.Error = outcome.Err.Error()
+	}
+	cb.TransactionID = outcome.Update.TransactionID
+	cb.Status = outcome.Update.Status
+	cb.PaymentID = outcome.Payment.ID
+	cb.ProcessedAt = primitive.NewDateTimeFromTime(time.Now())
+	if replay {
+		cb.Replays++
+	}
+
+	if !cb.ID.IsZero() {
+		if err := db.SetCallbackOutcome(*cb, replay); err != nil {
+			logger.ErrorLogger.Printf("Failed to record outcome of callback %s: %v", cb.ID.Hex(), err)
+		}
+	}
+	return outcome
+}
+
+var errUnverifiable = errors.New("callback can't be verified")
+
+// verify checks the callback with the instance's adapter when it can verify callbacks.
+// Callbacks not naming their instance can't be verified
+func verify(cfg *config.Config, instance string, headers map[string][]string, body []byte) (string, error) {
+	if instance == "" {
+		return models.CallbackUnverifiable, nil
+	}
+	runner, err := factory.Instance(cfg, instance)
+	if err != nil {
+		return models.CallbackRejected, err
+	}
+	verifier, ok := runner.(payment.CallbackVerifier)
+	if !ok {
+		return models.CallbackUnverifiable, nil
+	}
+	if err := verifier.VerifyCallback(http.Header(headers), body); err != nil {
+		return models.CallbackRejected, err
+	}
+	return models.CallbackVerified, nil
+}
+
+func redact(headers map[string][]string) map[string][]string {
+	stored := http.Header{}
+	for key, values := range headers {
+		stored[key] = values
+	}
+	for _, key := range redactedHeaders {
+		if stored.Get(key) != "" {
+			stored.Set(key, "[redacted]")
+		}
+	}
+	return stored
+}
+
+// Process reads a raw callback body with the instance's adapter (any adapter that
+// accepts it when instance is empty) and applies the status through the status rules.
+// The HTTP handler and replays both go through it
+func Process(ctx context.Context, db *database.Database, cfg *config.Config, instance string, body []byte) Outcome {
+	_, span := tracing.Start(ctx, "HandleCallback", attribute.String("aggregator", instance))
+	outcome := process(db, cfg, instance, body)
+	span.SetAttributes(attribute.String("result", outcome.Result), attribute.String("transaction_id", outcome.Update.TransactionID))
+	tracing.End(span, outcome.Err)
+
+	metrics.Callbacks.WithLabelValues(outcome.Result).Inc()
+	return outcome
+}
+
+func process(db *database.Database, cfg *config.Config, instance string, body []byte) Outcome {
+	// Let the aggregator's adapter read the transaction and its status
+	update, err := parse(cfg, instance, body)
+	if err != nil {
+		logger.WarningLogger.Printf("Callback could not be parsed: %v", err)
+		return Outcome{Result: ResultUnparsable, Err: err}
+	}
+
+	// Apply the status through the same path as the poller
+	paymentDoc, _, err := transition.Apply(db, instance, update, "callback")
+	result := resultOf(err)
+	switch result {
+	case ResultUnknownTransaction:
+		logger.WarningLogger.Printf("Callback for unknown transaction %s", update.TransactionID)
+		return Outcome{Result: result, Update: update, Err: err}
+	case ResultLate:
+		logger.WarningLogger.Printf("Callback for %s arrived after the payment expired, kept for re-opening", update.TransactionID)
+	case ResultIgnored:
+		logger.WarningLogger.Printf("Callback for %s ignored: %v", update.TransactionID, err)
+	case ResultError:
+		logger.ErrorLogger.Printf("Failed to apply callback for %s: %v", update.TransactionID, err)
+	}
+	return Outcome{Result: result, Update: update, Payment: paymentDoc, Err: err}
+}
+
+// resultOf is the result of applying a callback's status. A payment that kept changing
+// concurrently is an error, so the provider retries and the callback can be replayed
+func resultOf(err error) string {
+	switch {
+	case err == nil:
+		return ResultApplied
+	case errors.Is(err, database.ErrNotFound):
+		return ResultUnknownTransaction
+	case errors.Is(err, transition.ErrExpired):
+		return ResultLate
+	case errors.Is(err, transition.ErrNotAllowed):
+		return ResultIgnored
+	default:
+		return ResultError
+	}
+}
+
+// parse uses the named instance's adapter, or without one,
+// the first configured adapter that can read the body
+func parse(cfg *config.Config, instance string, body []byte) (payment.TransactionStatus, error) {
+	names := []string{instance}
+	if instance == "" {
+		names = names[:0]
+		for name := range cfg.Instances {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	err := errors.New("no aggregator accepts callbacks")
+	for _, name := range names {
+		runner, instanceErr := factory.Instance(cfg, name)
+		if instanceErr != nil {
+			err = instanceErr
+			continue
+		}
+		parser, ok := runner.(payment.CallbackParser)
+		if !ok {
+			continue
+		}
+
+		var update payment.TransactionStatus
+		if update, err = parser.ParseCallback(body); err == nil {
+			return update, nil
+		}
+	}
+	return payment.TransactionStatus{}, err
+}
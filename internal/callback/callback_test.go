@@ -0,0 +1,43 @@
+package callback
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/transition"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResultOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   string
+		replay bool // listed by ReplayFailed
+	}{
+		{"applied", nil, ResultApplied, false},
+		{"unknown transaction", database.ErrNotFound, ResultUnknownTransaction, true},
+		{"expired", fmt.Errorf("%w: callback reported confirmed", transition.ErrExpired), ResultLate, false},
+		{"not allowed", fmt.Errorf("%w: failed -> confirmed", transition.ErrNotAllowed), ResultIgnored, false},
+		{"conflict", &database.ConflictError{PaymentID: primitive.NewObjectID(), Version: 3}, ResultError, true},
+		{"database down", errors.New("connection refused"), ResultError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultOf(tt.err)
+			if got != tt.want {
+				t.Fatalf("resultOf(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+			replayed := false
+			for _, result := range FailedResults {
+				replayed = replayed || result == got
+			}
+			if replayed != tt.replay {
+				t.Fatalf("%s replayed = %v, want %v", got, replayed, tt.replay)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"payment-aggregator/internal/logger"
+)
+
+// ErrOpen is returned by Execute while the breaker rejects calls
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Settings controls when a breaker trips and how it recovers
+type Settings struct {
+	ConsecutiveFailures int           // trip after this many failures in a row
+	FailureRate         float64       // trip when the failure rate in the window reaches this (0-1)
+	MinRequests         int           // minimum calls in the window before FailureRate is considered
+	WindowSize          int           // number of recent calls the failure rate is computed over
+	CoolDown            time.Duration // how long to stay open before allowing trial calls
+	HalfOpenMaxCalls    int           // concurrent trial calls allowed while half-open
+}
+
+// DefaultSettings returns the settings used when nothing is configured
+func DefaultSettings() Settings {
+	return Settings{
+		ConsecutiveFailures: 5,
+		FailureRate:         0.5,
+		MinRequests:         10,
+		WindowSize:          20,
+		CoolDown:            30 * time.Second,
+		HalfOpenMaxCalls:    1,
+	}
+}
+
+// SettingsFromEnv reads CIRCUIT_BREAKER_* variables on top of the defaults
+func SettingsFromEnv() Settings {
+	s := DefaultSettings()
+	if v, err := strconv.Atoi(os.Getenv("CIRCUIT_BREAKER_CONSECUTIVE_FAILURES")); err == nil && v > 0 {
+		s.ConsecutiveFailures = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("CIRCUIT_BREAKER_FAILURE_RATE"), 64); err == nil && v > 0 && v <= 1 {
+		s.FailureRate = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CIRCUIT_BREAKER_MIN_REQUESTS")); err == nil && v > 0 {
+		s.MinRequests = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CIRCUIT_BREAKER_WINDOW_SIZE")); err == nil && v > 0 {
+		s.WindowSize = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("CIRCUIT_BREAKER_COOL_DOWN")); err == nil && v > 0 {
+		s.CoolDown = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CIRCUIT_BREAKER_HALF_OPEN_MAX_CALLS")); err == nil && v > 0 {
+		s.HalfOpenMaxCalls = v
+	}
+	return s
+}
+
+// Breaker is a closed/open/half-open circuit breaker, safe for concurrent use
+type Breaker struct {
+	name     string
+	settings Settings
+
+	mu               sync.Mutex
+	state            State
+	consecutive      int
+	window           []bool // ring buffer of recent outcomes, true = failure
+	windowPos        int
+	windowLen        int
+	openedAt         time.Time
+	halfOpenInFlight int
+	generation       uint64 // bumped on every state change, so late results of older calls are ignored
+
+	successes   int64
+	failures    int64
+	rejections  int64
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{
+		name:     name,
+		settings: settings,
+		window:   make([]bool, settings.WindowSize),
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state, moving open to half-open once the cool-down has passed
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked()
+	return b.state
+}
+
+// Allow reports whether a call would currently be let through, without reserving it
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked()
+	switch b.state {
+	case Open:
+		return false
+	case HalfOpen:
+		return b.halfOpenInFlight < b.settings.HalfOpenMaxCalls
+	default:
+		return true
+	}
+}
+
+// Execute runs fn if the breaker allows it and records the outcome.
+// isFailure decides which errors count against the provider; nil counts every error
+func (b *Breaker) Execute(fn func() error, isFailure func(error) bool) error {
+	t, ok := b.acquire()
+	if !ok {
+		return ErrOpen
+	}
+
+	err := fn()
+	failed := err != nil
+	if failed && isFailure != nil {
+		failed = isFailure(err)
+	}
+	b.record(t, failed)
+	return err
+}
+
+// ticket is the state a call was let through in
+type ticket struct {
+	generation uint64
+	halfOpen   bool
+}
+
+func (b *Breaker) acquire() (ticket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked()
+	switch b.state {
+	case Open:
+		b.rejections++
+		return ticket{}, false
+	case HalfOpen:
+		if b.halfOpenInFlight >= b.settings.HalfOpenMaxCalls {
+			b.rejections++
+			return ticket{}, false
+		}
+		b.halfOpenInFlight++
+	}
+	return ticket{generation: b.generation, halfOpen: b.state == HalfOpen}, true
+}
+
+func (b *Breaker) record(t ticket, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if failed {
+		b.failures++
+		b.lastFailure = time.Now()
+	} else {
+		b.successes++
+		b.lastSuccess = time.Now()
+	}
+
+	// a call that started before the last state change says nothing about the
+	// current state: a slow call from closed must not decide a half-open probe
+	if t.generation != b.generation {
+		return
+	}
+	if t.halfOpen && b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	b.pushLocked(failed)
+
+	switch {
+	case t.halfOpen && failed:
+		b.tripLocked("trial call failed")
+	case t.halfOpen:
+		b.setStateLocked(Closed)
+		b.resetWindowLocked()
+	case b.state == Closed && b.consecutive >= b.settings.ConsecutiveFailures:
+		b.tripLocked("consecutive failure threshold reached")
+	case b.state == Closed && b.windowLen >= b.settings.MinRequests && b.failureRateLocked() >= b.settings.FailureRate:
+		b.tripLocked("failure rate threshold reached")
+	}
+}
+
+func (b *Breaker) refreshLocked() {
+	if b.state == Open && time.Since(b.openedAt) >= b.settings.CoolDown {
+		b.setStateLocked(HalfOpen)
+		b.halfOpenInFlight = 0
+	}
+}
+
+func (b *Breaker) tripLocked(reason string) {
+	logger.WarningLogger.Printf("Circuit breaker %s opened: %s", b.name, reason)
+	b.setStateLocked(Open)
+	b.openedAt = time.Now()
+}
+
+func (b *Breaker) setStateLocked(state State) {
+	if b.state != state {
+		logger.InfoLogger.Printf("Circuit breaker %s: %s -> %s", b.name, b.state, state)
+		b.generation++
+	}
+	b.state = state
+}
+
+func (b *Breaker) pushLocked(failed bool) {
+	if len(b.window) == 0 {
+		return
+	}
+	b.window[b.windowPos] = failed
+	b.windowPos = (b.windowPos + 1) % len(b.window)
+	if b.windowLen < len(b.window) {
+		b.windowLen++
+	}
+}
+
+func (b *Breaker) resetWindowLocked() {
+	for i := range b.window {
+		b.window[i] = false
+	}
+	b.windowPos = 0
+	b.windowLen = 0
+	b.consecutive = 0
+}
+
+func (b *Breaker) failureRateLocked() float64 {
+	if b.windowLen == 0 {
+		return 0
+	}
+	failed := 0
+	for i := 0; i < b.windowLen; i++ {
+		if b.window[i] {
+			failed++
+		}
+	}
+	return float64(failed) / float64(b.windowLen)
+}
+
+// Snapshot is a point-in-time view of a breaker, used by the admin endpoint and metrics
+type Snapshot struct {
+	Name                string    `json:"name"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	FailureRate         float64   `json:"failure_rate"`
+	Successes           int64     `json:"successes"`
+	Failures            int64     `json:"failures"`
+	Rejections          int64     `json:"rejections"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+	LastSuccessAt       time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       time.Time `json:"last_failure_at,omitempty"`
+}
+
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked()
+	return Snapshot{
+		Name:                b.name,
+		State:               b.state.String(),
+		ConsecutiveFailures: b.consecutive,
+		FailureRate:         b.failureRateLocked(),
+		Successes:           b.successes,
+		Failures:            b.failures,
+		Rejections:          b.rejections,
+		OpenedAt:            b.openedAt,
+		LastSuccessAt:       b.lastSuccess,
+		LastFailureAt:       b.lastFailure,
+	}
+}
@@ -0,0 +1,110 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"payment-aggregator/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Discard()
+	os.Exit(m.Run())
+}
+
+var errProvider = errors.New("provider down")
+
+func testSettings() Settings {
+	return Settings{
+		ConsecutiveFailures: 2,
+		FailureRate:         1,
+		MinRequests:         100,
+		WindowSize:          10,
+		CoolDown:            time.Millisecond,
+		HalfOpenMaxCalls:    1,
+	}
+}
+
+func outcome(failed bool) error {
+	if failed {
+		return errProvider
+	}
+	return nil
+}
+
+func TestBreakerStates(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []bool // true = failure, run one after another
+		cooldown bool   // wait out the cool-down after the outcomes
+		want     State
+	}{
+		{"stays closed on success", []bool{false, false, false}, false, Closed},
+		{"one failure stays closed", []bool{true, false, true}, false, Closed},
+		{"trips on consecutive failures", []bool{true, true}, false, Open},
+		{"half-open after cool-down", []bool{true, true}, true, HalfOpen},
+		{"probe success closes", []bool{true, true, false}, false, Closed},
+		{"probe failure re-opens", []bool{true, true, true}, false, Open},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.name, testSettings())
+			for i, failed := range tt.outcomes {
+				if i == 2 {
+					time.Sleep(2 * time.Millisecond) // let the tripped breaker go half-open for the probe
+				}
+				_ = b.Execute(func() error { return outcome(failed) }, nil)
+			}
+			if tt.cooldown {
+				time.Sleep(2 * time.Millisecond)
+			}
+			if got := b.State(); got != tt.want {
+				t.Fatalf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		failed bool // outcome of the slow call started while closed
+	}{
+		{"stale success does not close half-open", false},
+		{"stale failure does not re-open half-open", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.name, testSettings())
+			slow, ok := b.acquire()
+			if !ok {
+				t.Fatal("closed breaker refused a call")
+			}
+			_ = b.Execute(func() error { return errProvider }, nil)
+			_ = b.Execute(func() error { return errProvider }, nil)
+			time.Sleep(2 * time.Millisecond)
+			if got := b.State(); got != HalfOpen {
+				t.Fatalf("state = %s, want half-open", got)
+			}
+			probe, ok := b.acquire()
+			if !ok {
+				t.Fatal("half-open breaker refused the probe")
+			}
+
+			b.record(slow, tt.failed)
+			if got := b.State(); got != HalfOpen {
+				t.Fatalf("after stale result state = %s, want half-open", got)
+			}
+			if b.Allow() {
+				t.Fatal("stale result freed the probe slot")
+			}
+
+			b.record(probe, false)
+			if got := b.State(); got != Closed {
+				t.Fatalf("after probe success state = %s, want closed", got)
+			}
+		})
+	}
+}
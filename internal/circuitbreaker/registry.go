@@ -0,0 +1,58 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// one breaker per aggregator, shared by every flow in the process
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+func init() {
+	// exposed on /debug/vars alongside the other runtime metrics
+	expvar.Publish("circuit_breakers", expvar.Func(func() interface{} {
+		return Snapshots()
+	}))
+}
+
+// For returns the breaker for an aggregator, creating it from env settings on first use
+func For(name string) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if b, ok := registry[name]; ok {
+		return b
+	}
+	b := New(name, SettingsFromEnv())
+	registry[name] = b
+	return b
+}
+
+// Snapshots returns the state of every registered breaker, sorted by name
+func Snapshots() []Snapshot {
+	registryMu.Lock()
+	breakers := make([]*Breaker, 0, len(registry))
+	for _, b := range registry {
+		breakers = append(breakers, b)
+	}
+	registryMu.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(breakers))
+	for _, b := range breakers {
+		snapshots = append(snapshots, b.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots
+}
+
+// HandleStatus serves the state of every breaker as JSON
+func HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Snapshots())
+}
@@ -1,4 +1,153 @@
 package config
 
-// We can add configuration loading logic here if needed
-// For example, loading from a file or environment variables
+import (
+	"fmt"
+	"os"
+	"payment-aggregator/payment"
+	"sort"
+	"strings"
+)
+
+// Config holds the settings shared by every part of the service.
+// Everything is read from environment variables (optionally loaded from .env)
+type Config struct {
+	// ServerAddr is where the HTTP server listens, SERVER_ADDR (default "localhost:8080",
+	// set it to e.g. ":8080" to listen on every interface)
+	ServerAddr string
+
+	// MetricsAddr is the internal listener serving /metrics to the scraper, METRICS_ADDR
+	// (default "localhost:9090"). "off" disables it
+	MetricsAddr string
+
+	// Instances are the configured aggregator accounts, by instance name
+	Instances map[string]payment.InstanceConfig
+
+	// Routes maps a route (e.g. "deposit") to its ordered failover chain of instance names,
+	// DefaultRoute is used for routes without their own chain
+	Routes       map[string][]string
+	DefaultRoute []string
+}
+
+// Load reads the configuration from the environment.
+//
+// Aggregator instances are declared as AGGREGATOR_INSTANCES=name:type,...
+// (e.g. brand_a:sansgetirsin,sandbox:sansgetirsin) and read their settings from
+// <NAME>_* variables. Without it, every registered adapter gets one instance
+// named after it, so the plain SANSGETIRSIN_* variables keep working.
+func Load() (*Config, error) {
+	cfg := &Config{
+		ServerAddr:  os.Getenv("SERVER_ADDR"),
+		MetricsAddr: os.Getenv("METRICS_ADDR"),
+		Instances:   map[string]payment.InstanceConfig{},
+		Routes:      map[string][]string{},
+	}
+
+	if cfg.ServerAddr == "" {
+		cfg.ServerAddr = "localhost:8080"
+	}
+	if cfg.MetricsAddr == "" {
+		cfg.MetricsAddr = "localhost:9090"
+	}
+
+	if declared := os.Getenv("AGGREGATOR_INSTANCES"); declared != "" {
+		for _, entry := range splitList(declared) {
+			name, aggregatorType, ok := strings.Cut(entry, ":")
+			if !ok || name == "" || aggregatorType == "" {
+				return nil, fmt.Errorf("invalid AGGREGATOR_INSTANCES entry %q, expected name:type", entry)
+			}
+			if _, exists := cfg.Instances[name]; exists {
+				return nil, fmt.Errorf("aggregator instance %s declared twice", name)
+			}
+			cfg.Instances[name] = payment.InstanceConfig{Name: name, Type: aggregatorType, Prefix: envPrefix(name)}
+		}
+	} else {
+		for _, d := range payment.Registered() {
+			cfg.Instances[d.Name] = payment.InstanceConfig{Name: d.Name, Type: d.Name, Prefix: envPrefix(d.Name)}
+		}
+	}
+
+	for _, instance := range cfg.Instances {
+		if _, ok := payment.Lookup(instance.Type); !ok {
+			return nil, fmt.Errorf("aggregator instance %s has unsupported type %s", instance.Name, instance.Type)
+		}
+	}
+
+	// AGGREGATOR_ROUTE_<ROUTE>=instance,... with AGGREGATOR as the default chain
+	cfg.DefaultRoute = splitList(os.Getenv("AGGREGATOR"))
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if route, ok := strings.CutPrefix(key, "AGGREGATOR_ROUTE_"); ok && value != "" {
+			cfg.Routes[strings.ToLower(route)] = splitList(value)
+		}
+	}
+
+	for route, chain := range cfg.Routes {
+		if err := cfg.checkChain(route, chain); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.checkChain("default", cfg.DefaultRoute); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Route returns the ordered chain of instance names for a route
+func (c *Config) Route(route string) ([]string, error) {
+	chain, ok := c.Routes[route]
+	if !ok {
+		chain = c.DefaultRoute
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no aggregators configured for route %s, set AGGREGATOR or AGGREGATOR_ROUTE_%s", route, strings.ToUpper(route))
+	}
+	return chain, nil
+}
+
+// Check reports every instance setting that is required but not set
+func (c *Config) Check() []error {
+	var problems []error
+	for _, name := range sortedNames(c.Instances) {
+		instance := c.Instances[name]
+		descriptor, _ := payment.Lookup(instance.Type)
+		for _, field := range descriptor.Config {
+			if field.Required && field.Default == "" && instance.Get(field.Name) == "" {
+				problems = append(problems, fmt.Errorf("aggregator instance %s: %s_%s is not set", name, instance.Prefix, field.Name))
+			}
+		}
+	}
+	return problems
+}
+
+func sortedNames(instances map[string]payment.InstanceConfig) []string {
+	names := make([]string, 0, len(instances))
+	for name := range instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) checkChain(route string, chain []string) error {
+	for _, name := range chain {
+		if _, ok := c.Instances[name]; !ok {
+			return fmt.Errorf("route %s uses unknown aggregator instance %s", route, name)
+		}
+	}
+	return nil
+}
+
+func envPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (db *Database) audit() *mongo.Collection {
+	return db.database.Collection("Audit")
+}
+
+// InsertAudit appends a provider exchange to the audit trail.
+// The audit trail is append-only, records only leave it when their retention expires.
+func (db *Database) InsertAudit(record models.AuditModel) error {
+	_, err := db.audit().InsertOne(context.Background(), record)
+	return err
+}
+
+// ListPaymentAudit returns the provider exchanges made for a payment, in the order they were sent:
+// those made while it was created, under its correlation ID, and those made for it since.
+func (db *Database) ListPaymentAudit(payment models.PaymentModel) ([]models.AuditModel, error) {
+	filter := bson.M{"payment_id": payment.ID}
+	if payment.CorrelationID != "" {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"correlation_id": payment.CorrelationID}}}
+	}
+	return db.listAudit(filter)
+}
+
+// ListCorrelationAudit returns the provider exchanges made under a correlation ID, in the order
+// they were sent. Deposits that failed are never stored, their exchanges are found this way.
+func (db *Database) ListCorrelationAudit(correlationID string) ([]models.AuditModel, error) {
+	return db.listAudit(bson.M{"correlation_id": correlationID})
+}
+
+func (db *Database) listAudit(filter bson.M) ([]models.AuditModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sent_at", Value: 1}})
+	cursor, err := db.audit().Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []models.AuditModel{}
+	err = cursor.All(context.Background(), &records)
+	return records, err
+}
+
+// DefaultAuditRetention is how long audit records are kept when AUDIT_RETENTION is not set
+const DefaultAuditRetention = 90 * 24 * time.Hour
+
+// AuditRetentionFromEnv reads AUDIT_RETENTION (e.g. 2160h), DefaultAuditRetention otherwise
+func AuditRetentionFromEnv() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("AUDIT_RETENTION")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultAuditRetention
+}
+
+// SetAuditRetention changes how long Mongo keeps audit records on the TTL index the
+// migrations create, failing with ErrNotFound before they did.
+func (db *Database) SetAuditRetention(retention time.Duration) error {
+	err := db.database.RunCommand(context.Background(), bson.D{
+		{Key: "collMod", Value: db.audit().Name()},
+		{Key: "index", Value: bson.M{"name": auditRetentionIndex, "expireAfterSeconds": int32(retention.Seconds())}},
+	}).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27) { // namespace or index not found
+		return ErrNotFound
+	}
+	return err
+}
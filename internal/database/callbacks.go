@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (db *Database) callbacks() *mongo.Collection {
+	return db.database.Collection("Callbacks")
+}
+
+// InsertCallback stores a raw callback as received and sets its ID.
+func (db *Database) InsertCallback(callback *models.CallbackModel) error {
+	result, err := db.callbacks().InsertOne(context.Background(), callback)
+	if err != nil {
+		return err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		callback.ID = id
+	}
+	return nil
+}
+
+// FindCallback returns a stored callback.
+func (db *Database) FindCallback(id primitive.ObjectID) (models.CallbackModel, error) {
+	var callback models.CallbackModel
+	err := db.callbacks().FindOne(context.Background(), bson.M{"_id": id}).Decode(&callback)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return callback, ErrNotFound
+	}
+	return callback, err
+}
+
+// SetCallbackOutcome records how a callback was processed, counting it as a replay when replay is set.
+// The raw callback itself is never changed.
+func (db *Database) SetCallbackOutcome(callback models.CallbackModel, replay bool) error {
+	set := bson.M{
+		"verification":   callback.Verification,
+		"result":         callback.Result,
+		"error":          callback.Error,
+		"transaction_id": callback.TransactionID,
+		"status":         callback.Status,
+		"processed_at":   callback.ProcessedAt,
+	}
+	if !callback.PaymentID.IsZero() {
+		set["payment_id"] = callback.PaymentID
+	}
+	update := bson.M{"$set": set}
+	if replay {
+		update["$inc"] = bson.M{"replays": 1}
+	}
+	_, err := db.callbacks().UpdateByID(context.Background(), callback.ID, update)
+	return err
+}
+
+// CallbackFilter narrows ListCallbacks, zero fields match everything
+type CallbackFilter struct {
+	Results   []string
+	PaymentID primitive.ObjectID
+	Limit     int64
+}
+
+// ListCallbacks returns stored callbacks, oldest first so replays keep the provider's order.
+func (db *Database) ListCallbacks(filter CallbackFilter) ([]models.CallbackModel, error) {
+	query := bson.M{}
+	if len(filter.Results) > 0 {
+		query["result"] = bson.M{"$in": filter.Results}
+	}
+	if !filter.PaymentID.IsZero() {
+		query["payment_id"] = filter.PaymentID
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "received_at", Value: 1}}).SetLimit(filter.Limit)
+	cursor, err := db.callbacks().Find(context.Background(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	callbacks := []models.CallbackModel{}
+	err = cursor.All(context.Background(), &callbacks)
+	return callbacks, err
+}
@@ -2,18 +2,52 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"payment-aggregator/internal/metrics"
 	"payment-aggregator/models"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// ErrNotFound is returned when a lookup matches no document
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is matched by a *ConflictError
+var ErrConflict = errors.New("payment was changed concurrently")
+
+// ConflictError is returned when a payment was updated by someone else since it was loaded,
+// the caller should reload it and decide again
+type ConflictError struct {
+	PaymentID primitive.ObjectID
+	Version   int64 // the version the update expected
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("payment %s is no longer at version %d: %v", e.PaymentID.Hex(), e.Version, ErrConflict)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ErrNotRefundable is returned when a deposit can't take a refund of the requested amount
+var ErrNotRefundable = errors.New("payment is not refundable for this amount")
+
+// amounts closer than this are considered equal
+const amountEpsilon = 0.005
+
 type Database struct {
 	client     *mongo.Client
-	collection *mongo.Collection
+	database   *mongo.Database
+	collection *mongo.Collection // payments
+	merchants  *mongo.Collection
 }
 
 // NewDatabase initializes a new MongoDB connection and returns a Database instance.
@@ -21,26 +55,343 @@ func NewDatabase(uri, dbName, collectionName string) (*Database, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(metrics.CommandMonitor()))
 	if err != nil {
 		return nil, err
 	}
 
-	collection := client.Database(dbName).Collection(collectionName)
+	database := client.Database(dbName)
 
 	return &Database{
 		client:     client,
-		collection: collection,
+		database:   database,
+		collection: database.Collection(collectionName),
+		merchants:  database.Collection("Merchants"),
 	}, nil
 }
 
-// InsertPayment inserts a new payment record into the database.
-func (db *Database) InsertPayment(payment models.PaymentModel) error {
+// InsertPayment inserts a new payment record into the database and sets its ID.
+func (db *Database) InsertPayment(payment *models.PaymentModel) error {
 	payment.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
-	_, err := db.collection.InsertOne(context.Background(), payment)
+	payment.Version = 1
+	result, err := db.collection.InsertOne(context.Background(), payment)
+	if err != nil {
+		return err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		payment.ID = id
+	}
+	return nil
+}
+
+// FindPayment returns one payment of a merchant, other merchants' payments are reported as not found.
+func (db *Database) FindPayment(merchantID, id primitive.ObjectID) (models.PaymentModel, error) {
+	var payment models.PaymentModel
+	err := db.collection.FindOne(context.Background(), bson.M{"_id": id, "merchant_id": merchantID}).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// FindPaymentByID returns a payment regardless of merchant, for operators and background jobs.
+func (db *Database) FindPaymentByID(id primitive.ObjectID) (models.PaymentModel, error) {
+	var payment models.PaymentModel
+	err := db.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// DepositVolume sums the deposits matching filter (e.g. a merchant or aggregator) made since,
+// leaving out failed and expired ones.
+func (db *Database) DepositVolume(filter bson.M, since time.Time) (float64, error) {
+	volume, _, err := db.TransactionTotals(models.TypeDeposit, filter, since)
+	return volume, err
+}
+
+// TransactionTotals sums and counts the transactions of a type matching filter made since,
+// leaving out failed and expired ones.
+func (db *Database) TransactionTotals(transactionType string, filter bson.M, since time.Time) (float64, int64, error) {
+	match := bson.M{
+		"transaction_type": transactionType,
+		"status":           bson.M{"$nin": bson.A{models.StatusFailed, models.StatusExpired}},
+		"created_at":       bson.M{"$gte": primitive.NewDateTimeFromTime(since)},
+	}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": nil, "volume": bson.M{"$sum": "$amount"}, "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := db.collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var result []struct {
+		Volume float64 `bson:"volume"`
+		Count  int64   `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &result); err != nil || len(result) == 0 {
+		return 0, 0, err
+	}
+	return result[0].Volume, result[0].Count, nil
+}
+
+// FindPaymentByTransactionID returns the payment an aggregator instance knows by transactionID.
+// An empty aggregator matches any.
+func (db *Database) FindPaymentByTransactionID(aggregator, transactionID string) (models.PaymentModel, error) {
+	filter := bson.M{"transaction_id": transactionID}
+	if aggregator != "" {
+		filter["aggregator"] = aggregator
+	}
+
+	var payment models.PaymentModel
+	err := db.collection.FindOne(context.Background(), filter).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// ListPaymentsToPoll returns pending payments created before olderThan whose next poll is due.
+func (db *Database) ListPaymentsToPoll(olderThan time.Time, limit int64) ([]models.PaymentModel, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	filter := bson.M{
+		"status":     bson.M{"$in": bson.A{models.StatusPending, models.StatusSuccess}},
+		"created_at": bson.M{"$lte": primitive.NewDateTimeFromTime(olderThan)},
+		"$or": bson.A{
+			bson.M{"next_poll_at": bson.M{"$exists": false}},
+			bson.M{"next_poll_at": bson.M{"$lte": now}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit)
+	cursor, err := db.collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.PaymentModel{}
+	err = cursor.All(context.Background(), &payments)
+	return payments, err
+}
+
+// ListPaymentsToExpire returns an aggregator's pending deposits that are past their expiry,
+// oldest first. Deposits without an expiry never expire.
+func (db *Database) ListPaymentsToExpire(aggregator string, limit int64) ([]models.PaymentModel, error) {
+	filter := bson.M{
+		"aggregator":       aggregator,
+		"transaction_type": models.TypeDeposit,
+		"status":           models.StatusPending,
+		"expires_at":       bson.M{"$lte": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit)
+	cursor, err := db.collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.PaymentModel{}
+	err = cursor.All(context.Background(), &payments)
+	return payments, err
+}
+
+// ReopenPayment gives a loaded payment a new expiry and the limit counters it reserved
+// again, with UpdatePayment.
+func (db *Database) ReopenPayment(payment models.PaymentModel, expiresAt time.Time, limitCounters []string) (models.PaymentModel, error) {
+	return db.UpdatePayment(payment, bson.M{"expires_at": primitive.NewDateTimeFromTime(expiresAt), "limit_counters": limitCounters})
+}
+
+// SetTransactionID stores the ID the aggregator knows a loaded payment by with UpdatePayment.
+func (db *Database) SetTransactionID(payment models.PaymentModel, transactionID string) (models.PaymentModel, error) {
+	return db.UpdatePayment(payment, bson.M{"transaction_id": transactionID})
+}
+
+// SchedulePoll records a poll of a payment and when the next one is due. Only the poller
+// reads these, so the version is left alone and a poll doesn't make concurrent status
+// changes conflict.
+func (db *Database) SchedulePoll(id primitive.ObjectID, attempts int, next time.Time) error {
+	update := bson.M{"$set": bson.M{"poll_attempts": attempts, "next_poll_at": primitive.NewDateTimeFromTime(next)}}
+	_, err := db.collection.UpdateByID(context.Background(), id, update)
+	return err
+}
+
+// UpdatePayment sets fields of a payment if it is still at the version it was loaded at,
+// bumping the version, and returns the updated payment. It fails with a *ConflictError
+// when someone else updated it first.
+func (db *Database) UpdatePayment(payment models.PaymentModel, set bson.M) (models.PaymentModel, error) {
+	return db.updatePayment(payment, set, nil)
+}
+
+func (db *Database) updatePayment(payment models.PaymentModel, set, push bson.M) (models.PaymentModel, error) {
+	filter := bson.M{"_id": payment.ID, "version": payment.Version}
+	if payment.Version == 0 {
+		// stored before payments were versioned
+		filter["version"] = bson.M{"$exists": false}
+	}
+	fields := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	for k, v := range set {
+		fields[k] = v
+	}
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	if push != nil {
+		update["$push"] = push
+	}
+
+	var updated models.PaymentModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&updated)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return updated, err
+	}
+
+	// tell a missing payment from one that moved on
+	if _, err := db.FindPaymentByID(payment.ID); err != nil {
+		return payment, err
+	}
+	return payment, &ConflictError{PaymentID: payment.ID, Version: payment.Version}
+}
+
+// UpdatePaymentStatus moves a loaded payment to status with UpdatePayment. When the
+// transition has postings to make, its pending posting is stored in the same update.
+func (db *Database) UpdatePaymentStatus(payment models.PaymentModel, status string, posting *models.PendingPostingModel) (models.PaymentModel, error) {
+	var push bson.M
+	if posting != nil {
+		push = bson.M{"pending_postings": *posting}
+	}
+	return db.updatePayment(payment, bson.M{"status": status}, push)
+}
+
+// CompletePosting removes a pending posting once it was made, by its ID, so the same
+// transition pending again after a re-open is left for its own posting. It leaves the
+// version alone, the payment itself didn't change.
+func (db *Database) CompletePosting(id primitive.ObjectID, posting models.PendingPostingModel) error {
+	match := bson.M{"id": posting.ID}
+	if posting.ID.IsZero() {
+		match = bson.M{"id": bson.M{"$exists": false}, "from": posting.From, "to": posting.To}
+	}
+	_, err := db.collection.UpdateByID(context.Background(), id, bson.M{"$pull": bson.M{"pending_postings": match}})
 	return err
 }
 
+// ListPendingPostings returns payments with a posting pending since before olderThan, oldest first.
+func (db *Database) ListPendingPostings(olderThan time.Time, limit int64) ([]models.PaymentModel, error) {
+	filter := bson.M{"pending_postings.at": bson.M{"$lte": primitive.NewDateTimeFromTime(olderThan)}}
+	opts := options.Find().SetSort(bson.D{{Key: "pending_postings.at", Value: 1}}).SetLimit(limit)
+	cursor, err := db.collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.PaymentModel{}
+	err = cursor.All(context.Background(), &payments)
+	return payments, err
+}
+
+// ReserveRefund adds amount to a confirmed deposit's refunded amount and moves it to
+// partially_refunded or refunded, in one atomic update so concurrent refunds can't
+// exceed the deposit. It fails with ErrNotRefundable when the balance is too low.
+func (db *Database) ReserveRefund(id primitive.ObjectID, amount float64) (models.PaymentModel, error) {
+	filter := bson.M{
+		"_id":              id,
+		"transaction_type": models.TypeDeposit,
+		"status":           bson.M{"$in": bson.A{models.StatusConfirmed, models.StatusPartiallyRefunded}},
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refunded_amount", 0}}, amount}},
+			bson.M{"$add": bson.A{"$amount", amountEpsilon}},
+		}},
+	}
+	return db.adjustRefunded(filter, amount, nil, ErrNotRefundable)
+}
+
+// ReleaseRefund gives back the amount a failed refund reserved with ReserveRefund on its
+// deposit. The refund is recorded on the deposit, so releasing it again is a no-op.
+func (db *Database) ReleaseRefund(refund models.PaymentModel) (models.PaymentModel, error) {
+	filter := bson.M{"_id": refund.OriginalPaymentID, "released_refunds": bson.M{"$ne": refund.ID}}
+	released := bson.M{"released_refunds": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$released_refunds", bson.A{}}}, bson.A{refund.ID}}}}
+	original, err := db.adjustRefunded(filter, -refund.Amount, released, ErrNotFound)
+	if errors.Is(err, ErrNotFound) {
+		// already released, or the deposit is gone
+		return db.FindPaymentByID(refund.OriginalPaymentID)
+	}
+	return original, err
+}
+
+func (db *Database) adjustRefunded(filter bson.M, delta float64, set bson.M, noMatch error) (models.PaymentModel, error) {
+	fields := bson.M{
+		"refunded_amount": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refunded_amount", 0}}, delta}},
+		"version":         bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+		"updated_at":      primitive.NewDateTimeFromTime(time.Now()),
+	}
+	for k, v := range set {
+		fields[k] = v
+	}
+	pipeline := bson.A{
+		bson.M{"$set": fields},
+		bson.M{"$set": bson.M{"status": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{"case": bson.M{"$gte": bson.A{"$refunded_amount", bson.M{"$subtract": bson.A{"$amount", amountEpsilon}}}}, "then": models.StatusRefunded},
+				bson.M{"case": bson.M{"$gt": bson.A{"$refunded_amount", amountEpsilon}}, "then": models.StatusPartiallyRefunded},
+			},
+			"default": models.StatusConfirmed,
+		}}}},
+	}
+
+	var payment models.PaymentModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.collection.FindOneAndUpdate(context.Background(), filter, pipeline, opts).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return payment, noMatch
+	}
+	return payment, err
+}
+
+// ListPayments returns a merchant's payments, newest first.
+func (db *Database) ListPayments(merchantID primitive.ObjectID, limit int64) ([]models.PaymentModel, error) {
+	return db.FindPayments(PaymentFilter{MerchantID: merchantID, Limit: limit})
+}
+
+// PaymentFilter narrows FindPayments, zero fields match everything
+type PaymentFilter struct {
+	MerchantID primitive.ObjectID
+	Status     string
+	Aggregator string
+	Limit      int64
+}
+
+// FindPayments returns the payments matching filter, newest first, for operators.
+func (db *Database) FindPayments(filter PaymentFilter) ([]models.PaymentModel, error) {
+	query := bson.M{}
+	if !filter.MerchantID.IsZero() {
+		query["merchant_id"] = filter.MerchantID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Aggregator != "" {
+		query["aggregator"] = filter.Aggregator
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(filter.Limit)
+	cursor, err := db.collection.Find(context.Background(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.PaymentModel{}
+	err = cursor.All(context.Background(), &payments)
+	return payments, err
+}
+
+// Ping checks that the primary is reachable
+func (db *Database) Ping(ctx context.Context) error {
+	return db.client.Ping(ctx, readpref.Primary())
+}
+
 // Close cleans up the database connection.
 func (db *Database) Close() error {
 	return db.client.Disconnect(context.Background())
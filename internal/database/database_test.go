@@ -0,0 +1,635 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	logger.Discard()
+	os.Exit(m.Run())
+}
+
+// testDatabase connects to MONGO_TEST_URI with a database of its own, dropped when
+// the test ends. Tests that need it are skipped without MONGO_TEST_URI
+func testDatabase(t *testing.T) *Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	db, err := NewDatabase(uri, fmt.Sprintf("aggregator_test_%d", time.Now().UnixNano()), "Payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.database.Drop(context.Background())
+		db.Close()
+	})
+	return db
+}
+
+func insertPayment(t *testing.T, db *Database, p models.PaymentModel) models.PaymentModel {
+	t.Helper()
+	if err := db.InsertPayment(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func confirmedDeposit(amount, refunded float64) models.PaymentModel {
+	status := models.StatusConfirmed
+	if refunded > 0 {
+		status = models.StatusPartiallyRefunded
+	}
+	return models.PaymentModel{
+		MerchantID:      primitive.NewObjectID(),
+		Amount:          amount,
+		RefundedAmount:  refunded,
+		Status:          status,
+		TransactionType: models.TypeDeposit,
+		Aggregator:      "sansgetirsin",
+	}
+}
+
+func TestReserveRefund(t *testing.T) {
+	db := testDatabase(t)
+
+	tests := []struct {
+		name         string
+		deposit      models.PaymentModel
+		amount       float64
+		wantErr      error
+		wantRefunded float64
+		wantStatus   string
+	}{
+		{"partial", confirmedDeposit(100, 0), 40, nil, 40, models.StatusPartiallyRefunded},
+		{"rest of a partial refund", confirmedDeposit(100, 40), 60, nil, 100, models.StatusRefunded},
+		{"whole", confirmedDeposit(100, 0), 100, nil, 100, models.StatusRefunded},
+		{"rounding within a cent", confirmedDeposit(100, 0), 100.004, nil, 100.004, models.StatusRefunded},
+		{"more than the deposit", confirmedDeposit(100, 0), 100.01, ErrNotRefundable, 0, models.StatusConfirmed},
+		{"more than what is left", confirmedDeposit(100, 70), 40, ErrNotRefundable, 70, models.StatusPartiallyRefunded},
+		{"pending deposit", models.PaymentModel{Amount: 100, Status: models.StatusPending, TransactionType: models.TypeDeposit}, 10, ErrNotRefundable, 0, models.StatusPending},
+		{"refund of a refund", models.PaymentModel{Amount: 100, Status: models.StatusConfirmed, TransactionType: models.TypeRefund}, 10, ErrNotRefundable, 0, models.StatusConfirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deposit := insertPayment(t, db, tt.deposit)
+			_, err := db.ReserveRefund(deposit.ID, tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			stored, err := db.FindPaymentByID(deposit.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(stored.RefundedAmount-tt.wantRefunded) > 1e-9 || stored.Status != tt.wantStatus {
+				t.Fatalf("refunded %.3f %s, want %.3f %s", stored.RefundedAmount, stored.Status, tt.wantRefunded, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReserveRefundConcurrently(t *testing.T) {
+	db := testDatabase(t)
+	deposit := insertPayment(t, db, confirmedDeposit(100, 0))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.ReserveRefund(deposit.ID, 30); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 3 {
+		t.Fatalf("%d refunds of 30 reserved on 100, want 3", reserved)
+	}
+}
+
+func TestReleaseRefund(t *testing.T) {
+	db := testDatabase(t)
+
+	tests := []struct {
+		name         string
+		refunded     float64
+		amount       float64
+		releases     int
+		wantRefunded float64
+		wantStatus   string
+	}{
+		{"whole refund", 100, 100, 1, 0, models.StatusConfirmed},
+		{"part of a partial refund", 70, 30, 1, 40, models.StatusPartiallyRefunded},
+		{"released twice", 70, 30, 2, 40, models.StatusPartiallyRefunded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deposit := confirmedDeposit(100, tt.refunded)
+			if tt.refunded >= 100 {
+				deposit.Status = models.StatusRefunded
+			}
+			deposit = insertPayment(t, db, deposit)
+			refund := models.PaymentModel{ID: primitive.NewObjectID(), Amount: tt.amount, TransactionType: models.TypeRefund, OriginalPaymentID: deposit.ID}
+
+			for i := 0; i < tt.releases; i++ {
+				if _, err := db.ReleaseRefund(refund); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stored, err := db.FindPaymentByID(deposit.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(stored.RefundedAmount-tt.wantRefunded) > 1e-9 || stored.Status != tt.wantStatus {
+				t.Fatalf("refunded %.2f %s, want %.2f %s", stored.RefundedAmount, stored.Status, tt.wantRefunded, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPendingPostings(t *testing.T) {
+	db := testDatabase(t)
+
+	tests := []struct {
+		name        string
+		posting     bool
+		wantPending int
+	}{
+		{"transition with postings", true, 1},
+		{"transition without postings", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := insertPayment(t, db, models.PaymentModel{Amount: 100, Status: models.StatusPending, TransactionType: models.TypeDeposit})
+			var posting *models.PendingPostingModel
+			if tt.posting {
+				posting = newPosting(models.StatusPending, models.StatusConfirmed)
+			}
+			updated, err := db.UpdatePaymentStatus(p, models.StatusConfirmed, posting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(updated.PendingPostings); got != tt.wantPending {
+				t.Fatalf("%d pending postings, want %d", got, tt.wantPending)
+			}
+
+			pending, err := db.ListPendingPostings(time.Now().Add(time.Second), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, q := range pending {
+				found = found || q.ID == p.ID
+			}
+			if found != tt.posting {
+				t.Fatalf("listed as pending: %v, want %v", found, tt.posting)
+			}
+
+			if tt.posting {
+				if err := db.CompletePosting(p.ID, updated.PendingPostings[0]); err != nil {
+					t.Fatal(err)
+				}
+				stored, _ := db.FindPaymentByID(p.ID)
+				if len(stored.PendingPostings) != 0 || stored.Version != updated.Version {
+					t.Fatalf("after completing: %d pending, version %d, want 0 and %d", len(stored.PendingPostings), stored.Version, updated.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestCompletePostingOfRepeatedTransition(t *testing.T) {
+	db := testDatabase(t)
+
+	// expired, re-opened and expired again before the first expiry's posting was made
+	p := insertPayment(t, db, models.PaymentModel{Amount: 100, Status: models.StatusPending, TransactionType: models.TypeDeposit})
+	first := newPosting(models.StatusPending, models.StatusExpired)
+	second := newPosting(models.StatusPending, models.StatusExpired)
+	p, err := db.UpdatePaymentStatus(p, models.StatusExpired, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err = db.UpdatePaymentStatus(p, models.StatusPending, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.UpdatePaymentStatus(p, models.StatusExpired, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CompletePosting(p.ID, *first); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.FindPaymentByID(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.PendingPostings) != 1 || stored.PendingPostings[0].ID != second.ID {
+		t.Fatalf("pending postings %+v, want only %s", stored.PendingPostings, second.ID.Hex())
+	}
+}
+
+func newPosting(from, to string) *models.PendingPostingModel {
+	return &models.PendingPostingModel{ID: primitive.NewObjectID(), From: from, To: to, At: primitive.NewDateTimeFromTime(time.Now())}
+}
+
+func TestReserveLimit(t *testing.T) {
+	db := testDatabase(t)
+	expires := time.Now().Add(time.Hour)
+	seed := func(amount float64, count int64) LimitSeed {
+		return func() (float64, int64, error) { return amount, count, nil }
+	}
+
+	tests := []struct {
+		name       string
+		seed       LimitSeed
+		amount     float64
+		maxAmount  float64
+		maxCount   int64
+		wantErr    error
+		wantAmount float64
+		wantCount  int64
+	}{
+		{"new counter", seed(0, 0), 100, 1000, 0, nil, 100, 1},
+		{"seeded from earlier payments", seed(800, 3), 100, 1000, 0, nil, 900, 4},
+		{"up to the amount cap", seed(900, 3), 100, 1000, 0, nil, 1000, 4},
+		{"past the amount cap", seed(950, 3), 100, 1000, 0, ErrLimitReached, 950, 3},
+		{"up to the count cap", seed(0, 4), 10, 0, 5, nil, 10, 5},
+		{"past the count cap", seed(0, 5), 10, 0, 5, ErrLimitReached, 0, 5},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("test:%d", i)
+			counter, err := db.ReserveLimit(key, tt.amount, tt.maxAmount, tt.maxCount, expires, tt.seed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if math.Abs(counter.Amount-tt.wantAmount) > 1e-9 || counter.Count != tt.wantCount {
+				t.Fatalf("counter %.2f / %d, want %.2f / %d", counter.Amount, counter.Count, tt.wantAmount, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestReserveLimitConcurrently(t *testing.T) {
+	db := testDatabase(t)
+	seed := func() (float64, int64, error) { return 0, 0, nil }
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.ReserveLimit("test:concurrent", 30, 100, 0, time.Now().Add(time.Hour), seed); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 3 {
+		t.Fatalf("%d deposits of 30 reserved on a cap of 100, want 3", reserved)
+	}
+}
+
+func TestReleasePaymentLimits(t *testing.T) {
+	db := testDatabase(t)
+	seed := func() (float64, int64, error) { return 0, 0, nil }
+	if _, err := db.ReserveLimit("test:release", 40, 100, 0, time.Now().Add(time.Hour), seed); err != nil {
+		t.Fatal(err)
+	}
+	p := insertPayment(t, db, models.PaymentModel{Amount: 40, Status: models.StatusFailed, TransactionType: models.TypeDeposit, LimitCounters: []string{"test:release"}})
+
+	// released twice, given back once
+	for i := 0; i < 2; i++ {
+		if err := db.ReleasePaymentLimits(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counter, err := db.ReserveLimit("test:release", 0, 0, 0, time.Now().Add(time.Hour), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Amount != 0 || counter.Count != 1 { // the count includes the zero reservation just made
+		t.Fatalf("counter %.2f / %d after release, want 0 / 1", counter.Amount, counter.Count)
+	}
+	stored, _ := db.FindPaymentByID(p.ID)
+	if len(stored.LimitCounters) != 0 {
+		t.Fatalf("payment still holds %v", stored.LimitCounters)
+	}
+}
+
+func TestListPaymentsToExpire(t *testing.T) {
+	db := testDatabase(t)
+	past := primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute))
+	future := primitive.NewDateTimeFromTime(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		payment models.PaymentModel
+		want    bool
+	}{
+		{"overdue", models.PaymentModel{Status: models.StatusPending, ExpiresAt: past}, true},
+		{"not yet due", models.PaymentModel{Status: models.StatusPending, ExpiresAt: future}, false},
+		{"no expiry", models.PaymentModel{Status: models.StatusPending}, false},
+		{"legacy success", models.PaymentModel{Status: models.StatusSuccess, ExpiresAt: past}, false},
+		{"confirmed", models.PaymentModel{Status: models.StatusConfirmed, ExpiresAt: past}, false},
+		{"held", models.PaymentModel{Status: models.StatusHeld, ExpiresAt: past}, false},
+		{"other aggregator", models.PaymentModel{Status: models.StatusPending, ExpiresAt: past, Aggregator: "brand_b"}, false},
+	}
+	want := map[primitive.ObjectID]string{}
+	for _, tt := range tests {
+		p := tt.payment
+		p.TransactionType = models.TypeDeposit
+		if p.Aggregator == "" {
+			p.Aggregator = "brand_a"
+		}
+		p = insertPayment(t, db, p)
+		if tt.want {
+			want[p.ID] = tt.name
+		}
+	}
+
+	payments, err := db.ListPaymentsToExpire("brand_a", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != len(want) {
+		t.Fatalf("listed %d payments, want %d", len(payments), len(want))
+	}
+	for _, p := range payments {
+		if _, ok := want[p.ID]; !ok {
+			t.Errorf("listed payment %s (%s), want only %v", p.ID.Hex(), p.Status, want)
+		}
+	}
+}
+
+func TestDuplicateTransactionIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		payments []models.PaymentModel
+		want     error
+	}{
+		{"unique", []models.PaymentModel{
+			{Aggregator: "brand_a", TransactionID: "TX1"},
+			{Aggregator: "brand_b", TransactionID: "TX1"},
+		}, nil},
+		{"without transaction IDs", []models.PaymentModel{
+			{Aggregator: "brand_a"},
+			{Aggregator: "brand_a"},
+		}, nil},
+		{"duplicate", []models.PaymentModel{
+			{Aggregator: "brand_a", TransactionID: "TX1"},
+			{Aggregator: "brand_a", TransactionID: "TX1"},
+		}, ErrDuplicateTransactionIDs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDatabase(t)
+			for _, p := range tt.payments {
+				insertPayment(t, db, p)
+			}
+			err := duplicateTransactionIDs(context.Background(), db.collection)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("duplicateTransactionIDs() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyDepositsUnresolved(t *testing.T) {
+	db := testDatabase(t)
+	legacy := insertPayment(t, db, models.PaymentModel{Status: models.StatusPending, TransactionType: models.TypeDeposit})
+	if _, err := db.collection.UpdateByID(context.Background(), legacy.ID, bson.M{"$set": bson.M{"legacy_status": models.StatusSuccess}}); err != nil {
+		t.Fatal(err)
+	}
+	recent := insertPayment(t, db, models.PaymentModel{Status: models.StatusPending, TransactionType: models.TypeDeposit,
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(time.Hour))})
+
+	var migration Migration
+	for _, m := range migrations {
+		if m.Version == 9 {
+			migration = m
+		}
+	}
+	if err := migration.Up(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[primitive.ObjectID]string{legacy.ID: models.StatusUnresolved, recent.ID: models.StatusPending} {
+		stored, err := db.FindPaymentByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != want {
+			t.Errorf("payment %s is %s, want %s", id.Hex(), stored.Status, want)
+		}
+	}
+
+	if err := migration.Down(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := db.FindPaymentByID(legacy.ID); stored.Status != models.StatusPending {
+		t.Errorf("legacy payment is %s after Down, want %s", stored.Status, models.StatusPending)
+	}
+}
+
+func TestUpdatePaymentVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		between func(db *Database, p models.PaymentModel) error // runs after the payment was loaded
+		want    error
+	}{
+		{"unchanged", func(*Database, models.PaymentModel) error { return nil }, nil},
+		{"polled", func(db *Database, p models.PaymentModel) error {
+			return db.SchedulePoll(p.ID, 1, time.Now().Add(time.Minute))
+		}, nil},
+		{"updated", func(db *Database, p models.PaymentModel) error {
+			_, err := db.UpdatePayment(p, bson.M{"expires_at": primitive.NewDateTimeFromTime(time.Now())})
+			return err
+		}, ErrConflict},
+		{"status changed", func(db *Database, p models.PaymentModel) error {
+			_, err := db.UpdatePaymentStatus(p, models.StatusFailed, nil)
+			return err
+		}, ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDatabase(t)
+			loaded := insertPayment(t, db, models.PaymentModel{Status: models.StatusPending, TransactionType: models.TypeDeposit})
+			if err := tt.between(db, loaded); err != nil {
+				t.Fatal(err)
+			}
+
+			updated, err := db.UpdatePaymentStatus(loaded, models.StatusConfirmed, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("UpdatePaymentStatus() = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if updated.Version != loaded.Version+1 || updated.Status != models.StatusConfirmed {
+				t.Fatalf("payment at version %d %s, want %d %s", updated.Version, updated.Status, loaded.Version+1, models.StatusConfirmed)
+			}
+		})
+	}
+}
+
+func TestUpdatePaymentConcurrently(t *testing.T) {
+	db := testDatabase(t)
+	loaded := insertPayment(t, db, models.PaymentModel{Status: models.StatusPending, TransactionType: models.TypeDeposit})
+
+	// every writer loaded the same version, only one may win
+	const writers = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won, conflicts := 0, 0
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.UpdatePaymentStatus(loaded, models.StatusConfirmed, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				won++
+			case errors.Is(err, ErrConflict):
+				conflicts++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 || conflicts != writers-1 {
+		t.Fatalf("%d updates won and %d conflicted, want 1 and %d", won, conflicts, writers-1)
+	}
+	stored, err := db.FindPaymentByID(loaded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != loaded.Version+1 {
+		t.Fatalf("payment at version %d, want %d", stored.Version, loaded.Version+1)
+	}
+}
+
+func TestSetTransactionID(t *testing.T) {
+	db := testDatabase(t)
+	refund := insertPayment(t, db, models.PaymentModel{Status: models.StatusPending, TransactionType: models.TypeRefund, Aggregator: "brand_a"})
+
+	updated, err := db.SetTransactionID(refund, "RF1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.TransactionID != "RF1" || updated.Version != refund.Version+1 {
+		t.Fatalf("refund %q at version %d, want %q at %d", updated.TransactionID, updated.Version, "RF1", refund.Version+1)
+	}
+	found, err := db.FindPaymentByTransactionID("brand_a", "RF1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != refund.ID {
+		t.Fatalf("found payment %s, want refund %s", found.ID.Hex(), refund.ID.Hex())
+	}
+	if _, err := db.SetTransactionID(refund, "RF2"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("SetTransactionID() on a stale refund = %v, want a conflict", err)
+	}
+}
+
+func TestReopenPayment(t *testing.T) {
+	db := testDatabase(t)
+	seed := func() (float64, int64, error) { return 0, 0, nil }
+	expired := insertPayment(t, db, models.PaymentModel{Amount: 40, Status: models.StatusExpired, TransactionType: models.TypeDeposit})
+	if _, err := db.ReserveLimit("test:reopen", 40, 100, 0, time.Now().Add(time.Hour), seed); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := db.ReopenPayment(expired, time.Now().Add(time.Hour), []string{"test:reopen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.LimitCounters) != 1 || reopened.ExpiresAt.Time().Before(time.Now()) {
+		t.Fatalf("reopened with counters %v expiring at %s", reopened.LimitCounters, reopened.ExpiresAt.Time())
+	}
+
+	// the reservation is given back when the payment fails or expires again
+	if err := db.ReleasePaymentLimits(reopened); err != nil {
+		t.Fatal(err)
+	}
+	counter, err := db.ReserveLimit("test:reopen", 0, 0, 0, time.Now().Add(time.Hour), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Amount != 0 {
+		t.Fatalf("counter at %.2f after release, want 0", counter.Amount)
+	}
+}
+
+func TestRevokeMerchantAPIKey(t *testing.T) {
+	db := testDatabase(t)
+	merchant := models.MerchantModel{Name: "test", APIKeys: []models.APIKeyModel{
+		{Prefix: "pk_leaked1", Hash: "hash-leaked"},
+		{Prefix: "pk_kept123", Hash: "hash-kept"},
+	}}
+	if err := db.InsertMerchant(&merchant); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.RevokeMerchantAPIKey(merchant.ID, "pk_leaked1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"revoked key refused", lookupErr(db, "hash-leaked"), ErrNotFound},
+		{"other key accepted", lookupErr(db, "hash-kept"), nil},
+		{"revoked again", revokeErr(db, merchant.ID, "pk_leaked1"), ErrNotFound},
+		{"unknown prefix", revokeErr(db, merchant.ID, "pk_unknown"), ErrNotFound},
+		{"unknown merchant", revokeErr(db, primitive.NewObjectID(), "pk_kept123"), ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Fatalf("got %v, want %v", tt.err, tt.want)
+			}
+		})
+	}
+}
+
+func lookupErr(db *Database, hash string) error {
+	_, err := db.FindMerchantByAPIKeyHash(hash)
+	return err
+}
+
+func revokeErr(db *Database, id primitive.ObjectID, prefix string) error {
+	_, err := db.RevokeMerchantAPIKey(id, prefix)
+	return err
+}
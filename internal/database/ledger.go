@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicate is returned when a document with the same key already exists
+var ErrDuplicate = errors.New("already exists")
+
+func (db *Database) ledger() *mongo.Collection {
+	return db.database.Collection("Ledger")
+}
+
+// InsertJournal appends a journal to the ledger, failing with ErrDuplicate if it was already posted.
+// The ledger is append-only, there is no update or delete.
+func (db *Database) InsertJournal(journal models.JournalModel) error {
+	_, err := db.ledger().InsertOne(context.Background(), journal)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// AccountBalance sums the lines posted to account up to and including at.
+func (db *Database) AccountBalance(account string, at time.Time) (float64, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"lines.account": account, "posted_at": bson.M{"$lte": primitive.NewDateTimeFromTime(at)}}},
+		bson.M{"$unwind": "$lines"},
+		bson.M{"$match": bson.M{"lines.account": account}},
+		bson.M{"$group": bson.M{"_id": nil, "balance": bson.M{"$sum": "$lines.amount"}}},
+	}
+	return db.sumLedger(pipeline, "balance")
+}
+
+// LedgerTotal sums every line in the ledger, which is zero when all journals balance.
+func (db *Database) LedgerTotal() (float64, error) {
+	pipeline := bson.A{
+		bson.M{"$unwind": "$lines"},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$lines.amount"}}},
+	}
+	return db.sumLedger(pipeline, "total")
+}
+
+// UnbalancedJournals returns the journals whose lines don't sum to zero.
+func (db *Database) UnbalancedJournals() ([]models.JournalModel, error) {
+	pipeline := bson.A{
+		bson.M{"$addFields": bson.M{"sum": bson.M{"$sum": "$lines.amount"}}},
+		bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{"sum": bson.M{"$gt": amountEpsilon}},
+			bson.M{"sum": bson.M{"$lt": -amountEpsilon}},
+		}}},
+	}
+	cursor, err := db.ledger().Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	journals := []models.JournalModel{}
+	err = cursor.All(context.Background(), &journals)
+	return journals, err
+}
+
+// ListJournals returns the journals posted for a payment, oldest first.
+func (db *Database) ListJournals(paymentID primitive.ObjectID) ([]models.JournalModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "posted_at", Value: 1}})
+	cursor, err := db.ledger().Find(context.Background(), bson.M{"payment_id": paymentID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	journals := []models.JournalModel{}
+	err = cursor.All(context.Background(), &journals)
+	return journals, err
+}
+
+func (db *Database) sumLedger(pipeline bson.A, field string) (float64, error) {
+	cursor, err := db.ledger().Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var result []bson.M
+	if err := cursor.All(context.Background(), &result); err != nil || len(result) == 0 {
+		return 0, err
+	}
+	sum, _ := result[0][field].(float64)
+	return sum, nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLimitReached is returned when a reservation would take a limit counter past its caps
+var ErrLimitReached = errors.New("limit reached")
+
+// LimitCounter is the amount and number of transactions reserved against one limit in one period
+type LimitCounter struct {
+	Key       string    `bson:"_id"`
+	Amount    float64   `bson:"amount"`
+	Count     int64     `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// LimitSeed reads what a counter starts from when it is first used in its period
+type LimitSeed func() (amount float64, count int64, err error)
+
+// ReserveLimit atomically adds amount and one transaction to the counter stored under key,
+// unless that takes it past maxAmount or maxCount (0 doesn't cap). A counter that doesn't
+// exist yet is created from seed and kept until expiresAt. When a cap is reached it returns
+// ErrLimitReached with the counter as it is.
+func (db *Database) ReserveLimit(key string, amount, maxAmount float64, maxCount int64, expiresAt time.Time, seed LimitSeed) (LimitCounter, error) {
+	filter := bson.M{"_id": key}
+	if maxAmount > 0 {
+		filter["amount"] = bson.M{"$lte": maxAmount - amount + amountEpsilon}
+	}
+	if maxCount > 0 {
+		filter["count"] = bson.M{"$lte": maxCount - 1}
+	}
+	update := bson.M{"$inc": bson.M{"amount": amount, "count": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	// the first attempt may find no counter yet, the second one always finds it
+	var counter LimitCounter
+	for attempt := 1; attempt <= 2; attempt++ {
+		err := db.limitCounters().FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&counter)
+		if err == nil {
+			return counter, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return counter, err
+		}
+
+		var created bool
+		if counter, created, err = db.seedLimitCounter(key, expiresAt, seed); err != nil {
+			return counter, err
+		}
+		if !created {
+			break
+		}
+	}
+	return counter, ErrLimitReached
+}
+
+// seedLimitCounter creates the counter under key from seed unless it exists,
+// returning the existing counter when it does
+func (db *Database) seedLimitCounter(key string, expiresAt time.Time, seed LimitSeed) (LimitCounter, bool, error) {
+	var counter LimitCounter
+	err := db.limitCounters().FindOne(context.Background(), bson.M{"_id": key}).Decode(&counter)
+	if err == nil {
+		return counter, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return counter, false, err
+	}
+
+	amount, count, err := seed()
+	if err != nil {
+		return counter, false, err
+	}
+	_, err = db.limitCounters().UpdateOne(context.Background(), bson.M{"_id": key},
+		bson.M{"$setOnInsert": bson.M{"amount": amount, "count": count, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// created by a concurrent reservation
+		err = nil
+	}
+	return counter, err == nil, err
+}
+
+// ReleaseLimit gives back a reservation made with ReserveLimit
+func (db *Database) ReleaseLimit(key string, amount float64) error {
+	_, err := db.limitCounters().UpdateOne(context.Background(), bson.M{"_id": key}, bson.M{"$inc": bson.M{"amount": -amount, "count": -1}})
+	return err
+}
+
+// ReleasePaymentLimits gives back the reservations a payment holds on its limit counters.
+// Each counter is removed from the payment before it is released, so releasing again is a
+// no-op; a crash in between leaves the counter too high rather than too low.
+func (db *Database) ReleasePaymentLimits(payment models.PaymentModel) error {
+	for _, key := range payment.LimitCounters {
+		result, err := db.collection.UpdateOne(context.Background(),
+			bson.M{"_id": payment.ID, "limit_counters": key},
+			bson.M{"$pull": bson.M{"limit_counters": key}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		if err := db.ReleaseLimit(key, payment.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) limitCounters() *mongo.Collection {
+	return db.database.Collection("LimitCounters")
+}
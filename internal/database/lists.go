@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (db *Database) listEntries() *mongo.Collection {
+	return db.database.Collection("ListEntries")
+}
+
+// InsertListEntry adds an entry to the blocklist or allowlist and sets its ID.
+func (db *Database) InsertListEntry(entry *models.ListEntryModel) error {
+	result, err := db.listEntries().InsertOne(context.Background(), entry)
+	if err != nil {
+		return err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		entry.ID = id
+	}
+	return nil
+}
+
+// ListEntryFilter narrows ListEntries, empty fields match anything
+type ListEntryFilter struct {
+	List     string
+	Kind     string
+	Inactive bool // include expired and removed entries
+}
+
+// ListEntries returns list entries, newest first.
+func (db *Database) ListEntries(filter ListEntryFilter) ([]models.ListEntryModel, error) {
+	query := bson.M{}
+	if filter.List != "" {
+		query["list"] = filter.List
+	}
+	if filter.Kind != "" {
+		query["kind"] = filter.Kind
+	}
+	if !filter.Inactive {
+		query["removed_at"] = bson.M{"$exists": false}
+		query["$or"] = bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}},
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := db.listEntries().Find(context.Background(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []models.ListEntryModel{}
+	err = cursor.All(context.Background(), &entries)
+	return entries, err
+}
+
+// RemoveListEntry takes an entry off its list, keeping it with who removed it.
+func (db *Database) RemoveListEntry(id primitive.ObjectID, removedBy string) (models.ListEntryModel, error) {
+	filter := bson.M{"_id": id, "removed_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"removed_by": removedBy, "removed_at": primitive.NewDateTimeFromTime(time.Now())}}
+
+	var entry models.ListEntryModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.listEntries().FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return entry, ErrNotFound
+	}
+	return entry, err
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertMerchant inserts a new merchant and sets its ID.
+func (db *Database) InsertMerchant(merchant *models.MerchantModel) error {
+	merchant.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	if merchant.Status == "" {
+		merchant.Status = models.MerchantActive
+	}
+	result, err := db.merchants.InsertOne(context.Background(), merchant)
+	if err != nil {
+		return err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		merchant.ID = id
+	}
+	return nil
+}
+
+// FindMerchant returns a merchant by ID.
+func (db *Database) FindMerchant(id primitive.ObjectID) (models.MerchantModel, error) {
+	return db.findMerchant(bson.M{"_id": id})
+}
+
+// FindMerchantByAPIKeyHash returns the merchant owning a non-revoked API key.
+func (db *Database) FindMerchantByAPIKeyHash(hash string) (models.MerchantModel, error) {
+	return db.findMerchant(bson.M{
+		"api_keys": bson.M{"$elemMatch": bson.M{"hash": hash, "revoked_at": bson.M{"$exists": false}}},
+	})
+}
+
+// ListMerchants returns every merchant.
+func (db *Database) ListMerchants() ([]models.MerchantModel, error) {
+	cursor, err := db.merchants.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	merchants := []models.MerchantModel{}
+	err = cursor.All(context.Background(), &merchants)
+	return merchants, err
+}
+
+// AddMerchantAPIKey appends a newly issued key to a merchant.
+func (db *Database) AddMerchantAPIKey(id primitive.ObjectID, key models.APIKeyModel) error {
+	result, err := db.merchants.UpdateByID(context.Background(), id, bson.M{"$push": bson.M{"api_keys": key}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeMerchantAPIKey revokes a merchant's active API key by its prefix and returns the
+// merchant, failing with ErrNotFound when the merchant has no such active key.
+func (db *Database) RevokeMerchantAPIKey(id primitive.ObjectID, prefix string) (models.MerchantModel, error) {
+	filter := bson.M{"_id": id, "api_keys": bson.M{"$elemMatch": bson.M{"prefix": prefix, "revoked_at": bson.M{"$exists": false}}}}
+	update := bson.M{"$set": bson.M{"api_keys.$.revoked_at": primitive.NewDateTimeFromTime(time.Now())}}
+
+	var merchant models.MerchantModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.merchants.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&merchant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return merchant, ErrNotFound
+	}
+	return merchant, err
+}
+
+// SetMerchantStatus activates or suspends a merchant.
+func (db *Database) SetMerchantStatus(id primitive.ObjectID, status string) error {
+	result, err := db.merchants.UpdateByID(context.Background(), id, bson.M{"$set": bson.M{"status": status}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *Database) findMerchant(filter bson.M) (models.MerchantModel, error) {
+	var merchant models.MerchantModel
+	err := db.merchants.FindOne(context.Background(), filter).Decode(&merchant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return merchant, ErrNotFound
+	}
+	return merchant, err
+}
+
+// SetMerchantLimits replaces a merchant's rate limits and concurrency quota.
+func (db *Database) SetMerchantLimits(id primitive.ObjectID, rateLimits map[string]models.RateLimitModel, maxConcurrent int) error {
+	update := bson.M{"$set": bson.M{"rate_limits": rateLimits, "max_concurrent": maxConcurrent}}
+	result, err := db.merchants.UpdateByID(context.Background(), id, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetMerchantFees replaces a merchant's fee schedules.
+func (db *Database) SetMerchantFees(id primitive.ObjectID, fees map[string]models.FeeScheduleModel) error {
+	result, err := db.merchants.UpdateByID(context.Background(), id, bson.M{"$set": bson.M{"fees": fees}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetMerchantTransactionLimits replaces a merchant's amount and count limits.
+func (db *Database) SetMerchantTransactionLimits(id primitive.ObjectID, limits map[string]models.TransactionLimitsModel) error {
+	result, err := db.merchants.UpdateByID(context.Background(), id, bson.M{"$set": bson.M{"transaction_limits": limits}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
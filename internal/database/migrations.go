@@ -0,0 +1,471 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned change to the schema: indexes, backfills, renames.
+// Up must be safe to run again after a partial failure, Down undoes it
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *Database) error
+	Down    func(ctx context.Context, db *Database) error
+}
+
+// MigrationStatus is a migration and when it was applied, zero if it wasn't
+type MigrationStatus struct {
+	Version   int                `bson:"_id" json:"version"`
+	Name      string             `bson:"name" json:"name"`
+	AppliedAt primitive.DateTime `bson:"applied_at" json:"applied_at,omitempty"`
+}
+
+// ErrMigrationLocked is returned when another process holds the migration lock for too long
+var ErrMigrationLocked = errors.New("migrations are locked by another process")
+
+// ErrDuplicateTransactionIDs is returned when payments share an aggregator and transaction ID,
+// which the unique index on them can't be built over
+var ErrDuplicateTransactionIDs = errors.New("payments share an aggregator and transaction ID, resolve them and migrate again")
+
+const (
+	migrationLockID      = "lock"
+	migrationLockWait    = time.Minute
+	migrationLockStale   = 10 * time.Minute // a lock older than this was left by a crashed process
+	migrationLockRefresh = time.Minute      // how often a running migration shows its lock isn't stale
+
+	// how many duplicate transaction IDs a failed migration reports
+	maxReportedDuplicates = 20
+
+	auditRetentionIndex = "sent_at_ttl"
+)
+
+// migrations in version order, append new ones at the end and never renumber
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "payment indexes",
+		Up: func(ctx context.Context, db *Database) error {
+			if err := duplicateTransactionIDs(ctx, db.collection); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db.collection, []mongo.IndexModel{
+				// every callback and poll looks payments up by the aggregator's transaction ID
+				{
+					Keys: bson.D{{Key: "aggregator", Value: 1}, {Key: "transaction_id", Value: 1}},
+					Options: options.Index().SetName("aggregator_transaction_id").SetUnique(true).
+						SetPartialFilterExpression(bson.M{"transaction_id": bson.M{"$gt": ""}}),
+				},
+				{Keys: bson.D{{Key: "transaction_id", Value: 1}}, Options: options.Index().SetName("transaction_id")},
+				{Keys: bson.D{{Key: "merchant_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("merchant_created_at")},
+				{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("status_created_at")},
+				{Keys: bson.D{{Key: "aggregator", Value: 1}, {Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}, Options: options.Index().SetName("aggregator_status_expires_at")},
+				{Keys: bson.D{{Key: "iban", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("iban_created_at")},
+				{Keys: bson.D{{Key: "original_payment_id", Value: 1}}, Options: options.Index().SetName("original_payment_id").SetSparse(true)},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return dropIndexes(ctx, db.collection, "aggregator_transaction_id", "transaction_id", "merchant_created_at",
+				"status_created_at", "aggregator_status_expires_at", "iban_created_at", "original_payment_id")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "merchant API key index",
+		Up: func(ctx context.Context, db *Database) error {
+			return createIndexes(ctx, db.merchants, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "api_keys.hash", Value: 1}}, Options: options.Index().SetName("api_key_hash").SetUnique(true).SetSparse(true)},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return dropIndexes(ctx, db.merchants, "api_key_hash")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "callback, audit, ledger and list indexes",
+		Up: func(ctx context.Context, db *Database) error {
+			if err := createIndexes(ctx, db.callbacks(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "received_at", Value: 1}}, Options: options.Index().SetName("payment_received_at")},
+				{Keys: bson.D{{Key: "result", Value: 1}, {Key: "received_at", Value: 1}}, Options: options.Index().SetName("result_received_at")},
+			}); err != nil {
+				return err
+			}
+			if err := createIndexes(ctx, db.audit(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "sent_at", Value: 1}}, Options: options.Index().SetName("payment_sent_at")},
+			}); err != nil {
+				return err
+			}
+			if err := createIndexes(ctx, db.ledger(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "posted_at", Value: 1}}, Options: options.Index().SetName("payment_posted_at")},
+				{Keys: bson.D{{Key: "lines.account", Value: 1}, {Key: "posted_at", Value: 1}}, Options: options.Index().SetName("account_posted_at")},
+			}); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db.listEntries(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "list", Value: 1}, {Key: "kind", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("list_kind_created_at")},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return errors.Join(
+				dropIndexes(ctx, db.callbacks(), "payment_received_at", "result_received_at"),
+				dropIndexes(ctx, db.audit(), "payment_sent_at"),
+				dropIndexes(ctx, db.ledger(), "payment_posted_at", "account_posted_at"),
+				dropIndexes(ctx, db.listEntries(), "list_kind_created_at"),
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "legacy success status to pending",
+		Up: func(ctx context.Context, db *Database) error {
+			// deposits stored before statuses were tracked said "success" as soon as they were made
+			_, err := db.collection.UpdateMany(ctx,
+				bson.M{"status": models.StatusSuccess},
+				bson.M{"$set": bson.M{"status": models.StatusPending, "legacy_status": models.StatusSuccess}})
+			return err
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			_, err := db.collection.UpdateMany(ctx,
+				bson.M{"legacy_status": models.StatusSuccess, "status": models.StatusPending},
+				bson.M{"$set": bson.M{"status": models.StatusSuccess}, "$unset": bson.M{"legacy_status": ""}})
+			return err
+		},
+	},
+	{
+		Version: 5,
+		Name:    "payment versions",
+		Up: func(ctx context.Context, db *Database) error {
+			_, err := db.collection.UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
+			return err
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			_, err := db.collection.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"version": ""}})
+			return err
+		},
+	},
+	{
+		Version: 6,
+		Name:    "pending postings index",
+		Up: func(ctx context.Context, db *Database) error {
+			return createIndexes(ctx, db.collection, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "pending_postings.at", Value: 1}}, Options: options.Index().SetName("pending_postings_at").SetSparse(true)},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return dropIndexes(ctx, db.collection, "pending_postings_at")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "audit correlation index",
+		Up: func(ctx context.Context, db *Database) error {
+			return createIndexes(ctx, db.audit(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "correlation_id", Value: 1}, {Key: "sent_at", Value: 1}}, Options: options.Index().SetName("correlation_sent_at").SetSparse(true)},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return dropIndexes(ctx, db.audit(), "correlation_sent_at")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "limit counters",
+		Up: func(ctx context.Context, db *Database) error {
+			if err := createIndexes(ctx, db.limitCounters(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0)},
+			}); err != nil {
+				return err
+			}
+			// new counters of a payer's IBAN are seeded from its payments
+			return createIndexes(ctx, db.collection, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "payer_iban", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("payer_iban_created_at").SetSparse(true)},
+			})
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return errors.Join(
+				dropIndexes(ctx, db.limitCounters(), "expires_at_ttl"),
+				dropIndexes(ctx, db.collection, "payer_iban_created_at"),
+			)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "legacy deposits unresolved",
+		Up: func(ctx context.Context, db *Database) error {
+			// the deposits migration 4 moved to pending have no expiry and may be years old,
+			// they are left for operators and late callbacks rather than polled or expired
+			_, err := db.collection.UpdateMany(ctx,
+				bson.M{"legacy_status": models.StatusSuccess, "status": models.StatusPending, "expires_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"status": models.StatusUnresolved}})
+			return err
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			_, err := db.collection.UpdateMany(ctx,
+				bson.M{"legacy_status": models.StatusSuccess, "status": models.StatusUnresolved},
+				bson.M{"$set": bson.M{"status": models.StatusPending}})
+			return err
+		},
+	},
+	{
+		Version: 10,
+		Name:    "audit retention index",
+		Up: func(ctx context.Context, db *Database) error {
+			err := createIndexes(ctx, db.audit(), []mongo.IndexModel{
+				{Keys: bson.D{{Key: "sent_at", Value: 1}}, Options: options.Index().SetName(auditRetentionIndex).
+					SetExpireAfterSeconds(int32(AuditRetentionFromEnv().Seconds()))},
+			})
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86) {
+				// created at startup before it was a migration, SetAuditRetention keeps its expiry
+				return nil
+			}
+			return err
+		},
+		Down: func(ctx context.Context, db *Database) error {
+			return dropIndexes(ctx, db.audit(), auditRetentionIndex)
+		},
+	},
+}
+
+func (db *Database) schemaMigrations() *mongo.Collection {
+	return db.database.Collection("schema_migrations")
+}
+
+func createIndexes(ctx context.Context, collection *mongo.Collection, indexes []mongo.IndexModel) error {
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return fmt.Errorf("failed to create indexes on %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+func dropIndexes(ctx context.Context, collection *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		_, err := collection.Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)) { // namespace or index not found
+			return fmt.Errorf("failed to drop index %s on %s: %w", name, collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// duplicateTransactionIDs fails with ErrDuplicateTransactionIDs, naming the first of them,
+// when payments share an aggregator and transaction ID
+func duplicateTransactionIDs(ctx context.Context, collection *mongo.Collection) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"transaction_id": bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"aggregator": "$aggregator", "transaction_id": "$transaction_id"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.aggregator", Value: 1}, {Key: "_id.transaction_id", Value: 1}}}},
+		{{Key: "$limit", Value: maxReportedDuplicates}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to look for duplicate transaction IDs: %w", err)
+	}
+	var groups []struct {
+		Key struct {
+			Aggregator    string `bson:"aggregator"`
+			TransactionID string `bson:"transaction_id"`
+		} `bson:"_id"`
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return fmt.Errorf("failed to look for duplicate transaction IDs: %w", err)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	duplicates := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids := make([]string, 0, len(g.IDs))
+		for _, id := range g.IDs {
+			ids = append(ids, id.Hex())
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%s/%s (%s)", g.Key.Aggregator, g.Key.TransactionID, strings.Join(ids, ", ")))
+	}
+	return fmt.Errorf("%w: %s", ErrDuplicateTransactionIDs, strings.Join(duplicates, "; "))
+}
+
+// Migrations returns every known migration with when it was applied, in version order.
+func (db *Database) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+	}
+	return statuses, nil
+}
+
+func (db *Database) appliedMigrations(ctx context.Context) (map[int]primitive.DateTime, error) {
+	cursor, err := db.schemaMigrations().Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []MigrationStatus
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := map[int]primitive.DateTime{}
+	for _, r := range records {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// MigrateUp applies the migrations not applied yet, up to and including version
+// (all of them when zero), and returns the ones it applied.
+func (db *Database) MigrateUp(ctx context.Context, version int) ([]MigrationStatus, error) {
+	unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []MigrationStatus
+	for _, m := range migrations {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := m.Up(ctx, db); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		record := MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: primitive.NewDateTimeFromTime(time.Now())}
+		if _, err := db.schemaMigrations().InsertOne(ctx, record); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) ran but could not be recorded: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, record)
+	}
+	return ran, nil
+}
+
+// MigrateDown undoes the last steps applied migrations, newest first, and returns them.
+func (db *Database) MigrateDown(ctx context.Context, steps int) ([]MigrationStatus, error) {
+	unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var undone []MigrationStatus
+	for i := len(migrations) - 1; i >= 0 && len(undone) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := m.Down(ctx, db); err != nil {
+			return undone, fmt.Errorf("undoing migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := db.schemaMigrations().DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return undone, fmt.Errorf("migration %d (%s) was undone but is still recorded: %w", m.Version, m.Name, err)
+		}
+		undone = append(undone, MigrationStatus{Version: m.Version, Name: m.Name})
+	}
+	return undone, nil
+}
+
+// lockMigrations keeps replicas starting together from migrating at the same time,
+// waiting up to a minute for another process to finish. The lock is refreshed while it is
+// held, so a long migration isn't taken for one left by a crashed process
+func (db *Database) lockMigrations(ctx context.Context) (func(), error) {
+	owner := primitive.NewObjectID()
+	deadline := time.Now().Add(migrationLockWait)
+	for {
+		now := time.Now()
+		_, err := db.schemaMigrations().InsertOne(ctx, bson.M{"_id": migrationLockID, "owner": owner, "locked_at": primitive.NewDateTimeFromTime(now)})
+		if err == nil {
+			stop := make(chan struct{})
+			refreshed := make(chan struct{})
+			go func() {
+				defer close(refreshed)
+				db.refreshMigrationLock(owner, stop)
+			}()
+			return func() {
+				close(stop)
+				<-refreshed
+				if _, err := db.schemaMigrations().DeleteOne(context.Background(), bson.M{"_id": migrationLockID, "owner": owner}); err != nil {
+					logger.ErrorLogger.Printf("Failed to release the migration lock: %v", err)
+				}
+			}, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		// take over a lock left by a crashed process
+		stale := primitive.NewDateTimeFromTime(now.Add(-migrationLockStale))
+		result, err := db.schemaMigrations().DeleteOne(ctx, bson.M{"_id": migrationLockID, "locked_at": bson.M{"$lt": stale}})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount > 0 {
+			continue
+		}
+
+		if now.After(deadline) {
+			return nil, ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// refreshMigrationLock moves the lock's locked_at forward every migrationLockRefresh until stop is closed
+func (db *Database) refreshMigrationLock(owner primitive.ObjectID, stop <-chan struct{}) {
+	ticker := time.NewTicker(migrationLockRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		result, err := db.schemaMigrations().UpdateOne(context.Background(),
+			bson.M{"_id": migrationLockID, "owner": owner},
+			bson.M{"$set": bson.M{"locked_at": primitive.NewDateTimeFromTime(time.Now())}})
+		switch {
+		case err != nil:
+			logger.ErrorLogger.Printf("Failed to refresh the migration lock: %v", err)
+		case result.MatchedCount == 0:
+			logger.ErrorLogger.Printf("Migration lock was taken over by another process")
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TakeToken atomically refills the token bucket stored under key and takes one token from it,
+// so the limit holds across every replica sharing the database.
+// It returns whether a token was taken and how many are left.
+func (db *Database) TakeToken(key string, rate float64, burst int) (bool, float64, error) {
+	now := time.Now()
+	elapsedSeconds := bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}},
+		1000,
+	}}
+
+	// refill, decide, then take, all in one update pipeline
+	pipeline := bson.A{
+		bson.M{"$set": bson.M{
+			"tokens": bson.M{"$min": bson.A{
+				float64(burst),
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", float64(burst)}}, bson.M{"$multiply": bson.A{elapsedSeconds, rate}}}},
+			}},
+			"updated_at": now,
+		}},
+		bson.M{"$set": bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}},
+		bson.M{"$set": bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}},
+	}
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.database.Collection("RateLimits").FindOneAndUpdate(context.Background(), bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	if err != nil {
+		return false, 0, err
+	}
+	return bucket.Allowed, bucket.Tokens, nil
+}
+
+// AcquireSlot atomically drops the expired holders of the concurrency slots stored under
+// key and adds holder when fewer than max remain. Holders expire after lease so the
+// slots of a replica that crashed mid-request come back on their own.
+func (db *Database) AcquireSlot(key, holder string, max int, lease time.Duration) (bool, error) {
+	now := time.Now()
+	pipeline := bson.A{
+		bson.M{"$set": bson.M{"holders": bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$holders", bson.A{}}},
+			"cond":  bson.M{"$gt": bson.A{"$$this.expires_at", now}},
+		}}}},
+		bson.M{"$set": bson.M{"acquired": bson.M{"$lt": bson.A{bson.M{"$size": "$holders"}, max}}}},
+		bson.M{"$set": bson.M{"holders": bson.M{"$cond": bson.A{
+			"$acquired",
+			bson.M{"$concatArrays": bson.A{"$holders", bson.A{bson.M{"id": holder, "expires_at": now.Add(lease)}}}},
+			"$holders",
+		}}}},
+	}
+
+	var slots struct {
+		Acquired bool `bson:"acquired"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.concurrencySlots().FindOneAndUpdate(context.Background(), bson.M{"_id": key}, pipeline, opts).Decode(&slots)
+	if err != nil {
+		return false, err
+	}
+	return slots.Acquired, nil
+}
+
+// ReleaseSlot gives back a slot taken with AcquireSlot
+func (db *Database) ReleaseSlot(key, holder string) error {
+	_, err := db.concurrencySlots().UpdateOne(context.Background(), bson.M{"_id": key}, bson.M{"$pull": bson.M{"holders": bson.M{"id": holder}}})
+	return err
+}
+
+func (db *Database) concurrencySlots() *mongo.Collection {
+	return db.database.Collection("ConcurrencySlots")
+}
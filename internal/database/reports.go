@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SettlementGroup is the payments of one business day, aggregator instance,
+// merchant, type and status, summed.
+type SettlementGroup struct {
+	Day             string             `bson:"day"`
+	Aggregator      string             `bson:"aggregator"`
+	MerchantID      primitive.ObjectID `bson:"merchant_id"`
+	TransactionType string             `bson:"transaction_type"`
+	Status          string             `bson:"status"`
+	Count           int                `bson:"count"`
+	Gross           float64            `bson:"gross"`
+	ProviderFees    float64            `bson:"provider_fees"`
+	Fees            float64            `bson:"fees"`
+	Net             float64            `bson:"net"`
+}
+
+// SettlementGroups groups the payments created in [from, to) by day in the given
+// time zone, aggregator, merchant, type and status. A zero merchantID includes every merchant.
+func (db *Database) SettlementGroups(from, to time.Time, timezone string, merchantID primitive.ObjectID) ([]SettlementGroup, error) {
+	match := bson.M{"created_at": bson.M{
+		"$gte": primitive.NewDateTimeFromTime(from),
+		"$lt":  primitive.NewDateTimeFromTime(to),
+	}}
+	if !merchantID.IsZero() {
+		match["merchant_id"] = merchantID
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{
+				"day":              bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at", "timezone": timezone}},
+				"aggregator":       "$aggregator",
+				"merchant_id":      "$merchant_id",
+				"transaction_type": "$transaction_type",
+				"status":           "$status",
+			},
+			"count":         bson.M{"$sum": 1},
+			"gross":         bson.M{"$sum": "$amount"},
+			"provider_fees": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$fees.provider_fee", 0}}},
+			"fees":          bson.M{"$sum": bson.M{"$ifNull": bson.A{"$fees.fee", 0}}},
+			// payments without fees, like refunds, are net their amount
+			"net": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$fees.net", bson.M{"$subtract": bson.A{"$amount", bson.M{"$ifNull": bson.A{"$fees.fee", 0}}}}}}},
+		}},
+		bson.M{"$replaceWith": bson.M{"$mergeObjects": bson.A{"$_id", bson.M{
+			"count": "$count", "gross": "$gross", "provider_fees": "$provider_fees", "fees": "$fees", "net": "$net",
+		}}}},
+		bson.M{"$sort": bson.D{
+			{Key: "day", Value: 1}, {Key: "aggregator", Value: 1}, {Key: "merchant_id", Value: 1},
+			{Key: "transaction_type", Value: 1}, {Key: "status", Value: 1},
+		}},
+	}
+
+	cursor, err := db.collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	groups := []SettlementGroup{}
+	err = cursor.All(context.Background(), &groups)
+	return groups, err
+}
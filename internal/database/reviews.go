@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotInReview is returned when a payment is not waiting in the review queue
+var ErrNotInReview = errors.New("payment is not waiting for review")
+
+// ListPaymentsForReview returns the review queue: payments the risk engine sent to review
+// that no operator has decided on yet, oldest first.
+func (db *Database) ListPaymentsForReview(limit int64) ([]models.PaymentModel, error) {
+	filter := bson.M{"risk.decision": models.RiskReview, "risk.review": bson.M{"$exists": false}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit)
+	cursor, err := db.collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.PaymentModel{}
+	err = cursor.All(context.Background(), &payments)
+	return payments, err
+}
+
+// SetRiskReview records an operator's decision on a payment in the review queue,
+// failing with ErrNotInReview if it isn't there (any more).
+func (db *Database) SetRiskReview(id primitive.ObjectID, review models.RiskReviewModel) (models.PaymentModel, error) {
+	filter := bson.M{"_id": id, "risk.decision": models.RiskReview, "risk.review": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"risk.review": review}, "$inc": bson.M{"version": 1}}
+
+	var payment models.PaymentModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return payment, ErrNotInReview
+	}
+	return payment, err
+}
@@ -0,0 +1,227 @@
+package deposit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"payment-aggregator/internal/audit"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/expiry"
+	"payment-aggregator/internal/fees"
+	"payment-aggregator/internal/limits"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/metrics"
+	"payment-aggregator/internal/risk"
+	"payment-aggregator/internal/screening"
+	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/internal/tracing"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Service runs deposits for merchants and stores them with their fees.
+// The HTTP API and the CLI both go through it
+type Service struct {
+	db       *database.Database
+	cfg      *config.Config
+	runner   payment.FlowRunner
+	fees     *fees.Engine
+	limits   *limits.Engine
+	risk     *risk.Engine
+	screener *screening.Screener
+	flows    shutdown.Group // deposits in progress
+
+	timeout time.Duration
+}
+
+// DefaultTimeout bounds a deposit flow when DEPOSIT_TIMEOUT is not set
+const DefaultTimeout = 2 * time.Minute
+
+// TimeoutFromEnv reads DEPOSIT_TIMEOUT, DefaultTimeout otherwise
+func TimeoutFromEnv() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("DEPOSIT_TIMEOUT")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultTimeout
+}
+
+func NewService(db *database.Database, cfg *config.Config, runner payment.FlowRunner) (*Service, error) {
+	feeEngine, err := fees.NewEngine(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	limitEngine, err := limits.NewEngine(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	riskEngine, err := risk.NewEngine(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{db: db, cfg: cfg, runner: runner, fees: feeEngine, limits: limitEngine, risk: riskEngine, screener: screening.NewScreener(db), timeout: TimeoutFromEnv()}, nil
+}
+
+// Payer is who the merchant says will pay, both fields optional. The name is checked against
+// the account holder, the IBAN counts towards IBAN_LIMITS
+type Payer struct {
+	Name string
+	IBAN string
+}
+
+// Deposit runs the deposit flow for merchant and stores the payment.
+// selectAccount picks the provider account, nil asks on the terminal.
+// The flow outlives ctx's cancellation, so a caller that goes away mid-way through the
+// provider call can't leave money moving without a stored payment, and is bounded by
+// DEPOSIT_TIMEOUT instead
+func (s *Service) Deposit(ctx context.Context, merchant models.MerchantModel, amount float64, payer Payer, selectAccount payment.AccountSelector) (payment.DepositResponse, models.PaymentModel, error) {
+	done, err := s.flows.Enter()
+	if err != nil {
+		return payment.DepositResponse{}, models.PaymentModel{}, err
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "Deposit", attribute.String("merchant_id", merchant.ID.Hex()), attribute.Float64("amount", amount))
+	resp, paymentDoc, err := s.deposit(ctx, merchant, amount, payer, selectAccount)
+	span.SetAttributes(attribute.String("aggregator", flowAggregator(paymentDoc)))
+	tracing.End(span, err)
+
+	metrics.Flows.WithLabelValues(models.TypeDeposit, flowAggregator(paymentDoc), metrics.Outcome(err)).Inc()
+	return resp, paymentDoc, err
+}
+
+func (s *Service) deposit(ctx context.Context, merchant models.MerchantModel, amount float64, payer Payer, selectAccount payment.AccountSelector) (payment.DepositResponse, models.PaymentModel, error) {
+	// the provider calls are audited under a correlation ID stored with the payment,
+	// a deposit that fails is never stored but its calls can still be found by it
+	correlationID := audit.NewCorrelationID(ctx)
+	ctx = audit.WithCorrelationID(ctx, correlationID)
+
+	// every aggregator tried is screened and assessed, the deposit keeps the assessment
+	// of the one that made it. Allowlisted account holders, IBANs and banks are exempt
+	// from the score-level risk rules
+	var allowed *models.ListEntryModel
+	screen := s.screener.DepositCheck(payer.Name, func(entry *models.ListEntryModel) { allowed = entry })
+
+	var assessment models.RiskModel
+	assess := func(ctx context.Context, candidate models.PaymentModel) error {
+		in := risk.Input{Merchant: merchant, Candidate: candidate, DeclaredPayer: payer.Name}
+		if allowed != nil {
+			in.AllowedBy = allowed.ID
+		}
+		var err error
+		assessment, err = s.risk.Check(ctx, in)
+		return err
+	}
+
+	// limits are reserved on every aggregator that gets that far, the deposit keeps the
+	// reservation of the one that made it and the others are given back
+	var reservations []limits.Reservation
+	reserve := s.limits.DepositCheck(merchant, func(r limits.Reservation) { reservations = append(reservations, r) })
+
+	payerIBAN, _ := screening.Normalize(models.ListKindIBAN, payer.IBAN)
+	resp, paymentDoc, err := s.runner.RunDepositFlow(ctx, payment.DepositRequest{
+		Amount:             amount,
+		MerchantID:         merchant.ID,
+		PayerIBAN:          payerIBAN,
+		AllowedAggregators: merchant.AllowedAggregators,
+		SelectAccount:      selectAccount,
+		Check:              payment.AllChecks(screen, reserve, assess),
+	})
+	paymentDoc.CorrelationID = correlationID
+	if err != nil {
+		for _, r := range reservations {
+			s.limits.Release(r)
+		}
+		return resp, paymentDoc, err
+	}
+	if n := len(reservations); n > 0 {
+		for _, r := range reservations[:n-1] {
+			s.limits.Release(r)
+		}
+		paymentDoc.LimitCounters = reservations[n-1].Keys
+	}
+
+	if assessment.Decision != "" {
+		paymentDoc.Risk = &assessment
+	}
+	if assessment.Decision == models.RiskReview {
+		logger.WarningLogger.Printf("Risk: deposit %s sent to review, score %d, it is held until approved", paymentDoc.TransactionID, assessment.Score)
+	}
+	paymentDoc.MerchantID = merchant.ID
+	if paymentDoc.Amount == 0 {
+		paymentDoc.Amount = amount
+	}
+
+	// abandoned deposits are expired by the sweeper
+	paymentDoc.ExpiresAt = primitive.NewDateTimeFromTime(time.Now().Add(expiry.Window(s.cfg, paymentDoc.Aggregator)))
+
+	// the money is already moving, a fee problem must not lose the payment
+	breakdown, err := s.fees.Calculate(merchant, paymentDoc.Aggregator, paymentDoc.Amount)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to calculate fees of %s: %v", paymentDoc.TransactionID, err)
+	} else {
+		paymentDoc.Fees = &breakdown
+	}
+
+	_, span := tracing.Start(ctx, "InsertPayment", attribute.String("transaction_id", paymentDoc.TransactionID))
+	err = s.db.InsertPayment(&paymentDoc)
+	tracing.End(span, err)
+	if err != nil {
+		s.limits.Release(limits.Reservation{Keys: paymentDoc.LimitCounters, Amount: amount})
+		return resp, paymentDoc, fmt.Errorf("deposit %s was made but could not be stored: %w", paymentDoc.TransactionID, err)
+	}
+	return resp, paymentDoc, nil
+}
+
+// Shutdown refuses new deposits and waits for the ones in progress,
+// so none is left made at the provider but not stored
+func (s *Service) Shutdown(ctx context.Context) error {
+	return s.flows.Drain(ctx)
+}
+
+// flowAggregator is the instance that made the payment, or the last one tried when none did
+func flowAggregator(paymentDoc models.PaymentModel) string {
+	if paymentDoc.Aggregator != "" {
+		return paymentDoc.Aggregator
+	}
+	if n := len(paymentDoc.Attempts); n > 0 {
+		return paymentDoc.Attempts[n-1].Aggregator
+	}
+	return "none"
+}
+
+// Preview is the fee breakdown a deposit would get on one aggregator instance
+type Preview struct {
+	Aggregator string           `json:"aggregator"`
+	Fees       models.FeesModel `json:"fees"`
+}
+
+// Preview returns the fees of a deposit of amount on every aggregator the merchant
+// may be routed to, in failover order
+func (s *Service) Preview(merchant models.MerchantModel, amount float64) ([]Preview, error) {
+	chain, err := s.cfg.Route("deposit")
+	if err != nil {
+		return nil, err
+	}
+
+	previews := []Preview{}
+	for _, aggregator := range chain {
+		if !merchant.AllowsAggregator(aggregator) {
+			continue
+		}
+		breakdown, err := s.fees.Calculate(merchant, aggregator, amount)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, Preview{Aggregator: aggregator, Fees: breakdown})
+	}
+	return previews, nil
+}
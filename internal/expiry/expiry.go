@@ -0,0 +1,225 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"payment-aggregator/internal/audit"
+	"payment-aggregator/internal/callback"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/limits"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/notify"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultWindow is how long a deposit may stay pending when neither
+// <PREFIX>_DEPOSIT_EXPIRY nor DEPOSIT_EXPIRY is set
+const DefaultWindow = 24 * time.Hour
+
+// Window is how long a deposit through the aggregator instance may stay pending
+// before it expires: <PREFIX>_DEPOSIT_EXPIRY, then DEPOSIT_EXPIRY
+func Window(cfg *config.Config, aggregator string) time.Duration {
+	def := envDuration("DEPOSIT_EXPIRY", DefaultWindow)
+	if instance, ok := cfg.Instances[aggregator]; ok {
+		return instance.GetDuration("DEPOSIT_EXPIRY", def)
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// Sweeper moves pending deposits past their expiry to expired and notifies their merchants
+type Sweeper struct {
+	db        *database.Database
+	cfg       *config.Config
+	interval  time.Duration
+	batchSize int64
+}
+
+// NewSweeper sweeps every EXPIRY_SWEEP_INTERVAL (default a minute)
+func NewSweeper(db *database.Database, cfg *config.Config) *Sweeper {
+	return &Sweeper{db: db, cfg: cfg, interval: envDuration("EXPIRY_SWEEP_INTERVAL", time.Minute), batchSize: 100}
+}
+
+// Run sweeps until ctx is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	logger.InfoLogger.Printf("Expiry sweeper started, sweeping every %s", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.SweepOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			logger.InfoLogger.Println("Expiry sweeper stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepOnce expires every overdue deposit of every configured instance, returning how many
+func (s *Sweeper) SweepOnce(ctx context.Context) int {
+	names := make([]string, 0, len(s.cfg.Instances))
+	for name := range s.cfg.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expired := 0
+	for _, name := range names {
+		payments, err := s.db.ListPaymentsToExpire(name, s.batchSize)
+		if err != nil {
+			logger.ErrorLogger.Printf("Expiry: failed to list overdue deposits of %s: %v", name, err)
+			continue
+		}
+		for _, paymentDoc := range payments {
+			if ctx.Err() != nil {
+				return expired
+			}
+			if s.expire(ctx, paymentDoc) {
+				expired++
+			}
+		}
+	}
+	return expired
+}
+
+// expire moves an overdue deposit to expired, unless its aggregator says it is no longer pending
+func (s *Sweeper) expire(ctx context.Context, paymentDoc models.PaymentModel) bool {
+	if !s.stillPending(ctx, paymentDoc) {
+		return false
+	}
+
+	updated, changed, err := transition.To(s.db, paymentDoc, models.StatusExpired, "expiry")
+	if errors.Is(err, transition.ErrNotAllowed) || errors.Is(err, database.ErrConflict) {
+		return false // a callback or the poller got there first
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Expiry: failed to expire payment %s: %v", paymentDoc.ID.Hex(), err)
+		return false
+	}
+	if !changed {
+		return false
+	}
+
+	merchant, err := s.db.FindMerchant(updated.MerchantID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		logger.ErrorLogger.Printf("Expiry: failed to load merchant of %s, falling back to CALLBACK_URL: %v", updated.ID.Hex(), err)
+	}
+	notify.Merchant(ctx, merchant, updated)
+	return true
+}
+
+// stillPending asks the aggregator about an overdue deposit before it is expired, so one paid
+// whose callback never arrived is confirmed rather than expired. A deposit the aggregator
+// can't be asked about is taken as still pending; when asking fails, it is left for the next sweep
+func (s *Sweeper) stillPending(ctx context.Context, paymentDoc models.PaymentModel) bool {
+	runner, err := factory.Instance(s.cfg, paymentDoc.Aggregator)
+	if err != nil {
+		logger.ErrorLogger.Printf("Expiry: payment %s: %v", paymentDoc.ID.Hex(), err)
+		return false
+	}
+	querier, ok := runner.(payment.StatusQuerier)
+	if !ok {
+		return true
+	}
+
+	update, err := querier.GetTransactionStatus(audit.WithPayment(ctx, paymentDoc.ID), paymentDoc.TransactionID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Expiry: status query for %s failed, not expiring it yet: %v", paymentDoc.TransactionID, err)
+		return false
+	}
+	if update.Status == models.StatusPending {
+		return true
+	}
+	if update.TransactionID == "" {
+		update.TransactionID = paymentDoc.TransactionID
+	}
+
+	logger.InfoLogger.Printf("Expiry: overdue payment %s is %s at %s, not expiring it", paymentDoc.ID.Hex(), update.Status, paymentDoc.Aggregator)
+	if _, _, err := transition.Apply(s.db, paymentDoc.Aggregator, update, "expiry"); err != nil && !errors.Is(err, database.ErrConflict) {
+		logger.ErrorLogger.Printf("Expiry: failed to apply status of %s: %v", paymentDoc.TransactionID, err)
+	}
+	return false
+}
+
+// Reopen moves an expired deposit back to pending, for another window (the
+// aggregator's when zero), and replays the callbacks that arrived after it expired.
+// Expiring gave back the deposit's limit reservations, so they are taken again first and
+// a deposit that would now break a limit is refused with a *payment.Rejection.
+// It returns the payment as the replays left it and the replayed callbacks
+func Reopen(ctx context.Context, db *database.Database, cfg *config.Config, id primitive.ObjectID, by string, window time.Duration) (models.PaymentModel, []models.CallbackModel, error) {
+	paymentDoc, err := db.FindPaymentByID(id)
+	if err != nil {
+		return paymentDoc, nil, err
+	}
+	if paymentDoc.Status != models.StatusExpired {
+		return paymentDoc, nil, fmt.Errorf("%w: payment is %s, not expired", transition.ErrNotAllowed, paymentDoc.Status)
+	}
+	if window <= 0 {
+		window = Window(cfg, paymentDoc.Aggregator)
+	}
+
+	merchant, err := db.FindMerchant(paymentDoc.MerchantID)
+	if err != nil {
+		return paymentDoc, nil, fmt.Errorf("failed to load merchant: %w", err)
+	}
+	limitEngine, err := limits.NewEngine(db, cfg)
+	if err != nil {
+		return paymentDoc, nil, err
+	}
+	reservation, err := limitEngine.Reserve(merchant, paymentDoc)
+	if err != nil {
+		return paymentDoc, nil, err
+	}
+
+	// the new expiry is set first so the sweeper doesn't expire it again straight away
+	reopened, err := db.ReopenPayment(paymentDoc, time.Now().Add(window), reservation.Keys)
+	if err != nil {
+		limitEngine.Release(reservation)
+		return paymentDoc, nil, err
+	}
+	paymentDoc = reopened
+	if _, _, err := transition.To(db, paymentDoc, models.StatusPending, "re-opened by "+by); err != nil {
+		if releaseErr := db.ReleasePaymentLimits(paymentDoc); releaseErr != nil {
+			logger.ErrorLogger.Printf("Expiry: failed to give back the limits of %s: %v", paymentDoc.ID.Hex(), releaseErr)
+		}
+		return paymentDoc, nil, err
+	}
+
+	late, err := db.ListCallbacks(database.CallbackFilter{PaymentID: id, Results: []string{callback.ResultLate}, Limit: 100})
+	if err != nil {
+		return paymentDoc, nil, fmt.Errorf("payment re-opened but its late callbacks could not be listed: %w", err)
+	}
+	replayed := make([]models.CallbackModel, 0, len(late))
+	for _, cb := range late {
+		stored, _, err := callback.Replay(ctx, db, cfg, cb.ID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Expiry: failed to replay late callback %s: %v", cb.ID.Hex(), err)
+			continue
+		}
+		replayed = append(replayed, stored)
+	}
+
+	paymentDoc, err = db.FindPaymentByID(id)
+	return paymentDoc, replayed, err
+}
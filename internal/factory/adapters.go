@@ -0,0 +1,7 @@
+package factory
+
+// Adapters register themselves in the payment registry from init,
+// importing them here makes them available to every route
+import (
+	_ "payment-aggregator/payment/paymentMethods/sansgetirsin"
+)
@@ -0,0 +1,48 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"payment-aggregator/internal/circuitbreaker"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+)
+
+// breakerRunner guards a FlowRunner with its aggregator's circuit breaker
+type breakerRunner struct {
+	breaker *circuitbreaker.Breaker
+	runner  payment.FlowRunner
+}
+
+var _ payment.HealthChecker = &breakerRunner{}
+
+func withBreaker(name string, runner payment.FlowRunner) payment.FlowRunner {
+	return &breakerRunner{breaker: circuitbreaker.For(name), runner: runner}
+}
+
+// Healthy lets the failover chain skip the aggregator while its breaker is open
+func (b *breakerRunner) Healthy() bool {
+	return b.breaker.Allow()
+}
+
+func (b *breakerRunner) RunDepositFlow(ctx context.Context, req payment.DepositRequest) (payment.DepositResponse, models.PaymentModel, error) {
+	var resp payment.DepositResponse
+	var paymentDoc models.PaymentModel
+
+	err := b.breaker.Execute(func() error {
+		var err error
+		resp, paymentDoc, err = b.runner.RunDepositFlow(ctx, req)
+		return err
+	}, payment.IsProviderFailure)
+
+	if errors.Is(err, circuitbreaker.ErrOpen) {
+		// nothing was sent to the provider, so the chain may move on
+		return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{
+			Stage: payment.StageSession,
+			Err:   fmt.Errorf("%s: %w", b.breaker.Name(), err),
+		}
+	}
+	return resp, paymentDoc, err
+}
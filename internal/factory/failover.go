@@ -0,0 +1,105 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attempt outcomes recorded on the payment
+const (
+	AttemptSucceeded = "succeeded"
+	AttemptFailed    = "failed"
+	AttemptSkipped   = "skipped"
+)
+
+type routeEntry struct {
+	name   string
+	runner payment.FlowRunner
+}
+
+// FailoverRunner runs a flow on an ordered chain of aggregators,
+// moving to the next one when the current one is unhealthy or
+// fails before any money-moving call
+type FailoverRunner struct {
+	route   string
+	entries []routeEntry
+}
+
+var _ payment.FlowRunner = &FailoverRunner{}
+
+func (f *FailoverRunner) RunDepositFlow(ctx context.Context, req payment.DepositRequest) (payment.DepositResponse, models.PaymentModel, error) {
+	var attempts []models.AttemptModel
+	var lastErr error
+
+	for _, entry := range f.entries {
+		if !allowed(req.AllowedAggregators, entry.name) {
+			continue
+		}
+
+		if checker, ok := entry.runner.(payment.HealthChecker); ok && !checker.Healthy() {
+			logger.WarningLogger.Printf("Route %s: skipping unhealthy aggregator %s", f.route, entry.name)
+			attempts = append(attempts, newAttempt(entry.name, AttemptSkipped, "", "aggregator unhealthy"))
+			lastErr = fmt.Errorf("aggregator %s is unhealthy", entry.name)
+			continue
+		}
+
+		resp, paymentDoc, err := entry.runner.RunDepositFlow(ctx, req)
+		if err == nil {
+			paymentDoc.Attempts = append(attempts, newAttempt(entry.name, AttemptSucceeded, "", ""))
+			return resp, paymentDoc, nil
+		}
+
+		stage := ""
+		var flowErr *payment.FlowError
+		if errors.As(err, &flowErr) {
+			stage = flowErr.Stage
+		}
+		attempts = append(attempts, newAttempt(entry.name, AttemptFailed, stage, err.Error()))
+		lastErr = err
+
+		if !payment.CanFailover(err) {
+			// money may already have moved, retrying elsewhere could double charge
+			return payment.DepositResponse{}, models.PaymentModel{Attempts: attempts}, err
+		}
+		logger.WarningLogger.Printf("Route %s: aggregator %s failed at %s stage, trying next: %v", f.route, entry.name, stage, err)
+		trace.SpanFromContext(ctx).AddEvent("failover", trace.WithAttributes(
+			attribute.String("aggregator", entry.name), attribute.String("stage", stage), attribute.String("reason", err.Error())))
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no allowed aggregators configured")
+	}
+	return payment.DepositResponse{}, models.PaymentModel{Attempts: attempts}, fmt.Errorf("all aggregators failed for route %s: %w", f.route, lastErr)
+}
+
+func newAttempt(aggregator, outcome, stage, reason string) models.AttemptModel {
+	return models.AttemptModel{
+		Aggregator:  aggregator,
+		Outcome:     outcome,
+		Stage:       stage,
+		Reason:      reason,
+		AttemptedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+}
+
+func allowed(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,25 +2,78 @@ package factory
 
 import (
 	"fmt"
-	"os"
+	"payment-aggregator/internal/config"
 	"payment-aggregator/payment"
-	"payment-aggregator/payment/paymentMethods/sansgetirsin"
+	"sync"
 )
 
+// FlowRunnerFromEnv returns the failover chain for the deposit route
 func FlowRunnerFromEnv() (payment.FlowRunner, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	return FlowRunnerForRoute(cfg, "deposit")
+}
 
-	aggregatorName := os.Getenv("AGGREGATOR")
+// FlowRunnerForRoute builds the ordered failover chain of aggregator instances for a route
+func FlowRunnerForRoute(cfg *config.Config, route string) (payment.FlowRunner, error) {
+	chain, err := cfg.Route(route)
+	if err != nil {
+		return nil, err
+	}
+	return FlowRunnerForChain(cfg, route, chain)
+}
 
-	if aggregatorName == "" {
-		return nil, fmt.Errorf("AGGREGATOR environment variable is missing")
+// FlowRunnerForChain builds a failover chain of the given instances, e.g. to pin a
+// flow to one aggregator. route only names the chain in logs
+func FlowRunnerForChain(cfg *config.Config, route string, chain []string) (payment.FlowRunner, error) {
+	failover := &FailoverRunner{route: route}
+	for _, instanceName := range chain {
+		runner, err := Instance(cfg, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		failover.entries = append(failover.entries, routeEntry{name: instanceName, runner: withBreaker(instanceName, runner)})
 	}
 
-	switch aggregatorName {
-	case "sansgetirsin":
-		return sansgetirsin.NewFromEnv(), nil
+	return failover, nil
+}
+
+// adapters are built once per instance so every route shares their session tokens
+var (
+	instancesMu sync.Mutex
+	instances   = map[string]payment.FlowRunner{}
+)
+
+// Instance returns the adapter of a configured aggregator instance
+func Instance(cfg *config.Config, name string) (payment.FlowRunner, error) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
 
-	// Add other aggregators with their custom flow implementations
-	default:
-		return nil, fmt.Errorf("unsupported aggregator: %s", aggregatorName)
+	if runner, ok := instances[name]; ok {
+		return runner, nil
+	}
+
+	instance, ok := cfg.Instances[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregator instance: %s", name)
+	}
+	runner, err := newFlowRunner(instance)
+	if err != nil {
+		return nil, err
+	}
+	instances[name] = runner
+	return runner, nil
+}
+
+func newFlowRunner(instance payment.InstanceConfig) (payment.FlowRunner, error) {
+	descriptor, ok := payment.Lookup(instance.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregator: %s", instance.Type)
+	}
+	if !descriptor.Supports(payment.CapabilityDeposit) {
+		return nil, fmt.Errorf("aggregator %s does not support deposits", instance.Name)
 	}
+	return descriptor.New(instance), nil
 }
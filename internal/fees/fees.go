@@ -0,0 +1,159 @@
+package fees
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+	_ "time/tzdata" // business months need Europe/Istanbul even where the OS has no zone data
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/report"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Engine calculates the fee breakdown of a payment.
+//
+// The provider fee comes from the instance's <PREFIX>_PROVIDER_FEES schedule.
+// Our fee comes from the merchant's schedule for the instance, then the merchant's
+// "default" schedule, then <PREFIX>_MERCHANT_FEES, then MERCHANT_FEES.
+// Schedules in variables are JSON, e.g. {"fixed": 1, "percentage": 1.5, "min": 2}.
+// Tiers are by volume since the start of the month in Europe/Istanbul
+type Engine struct {
+	db       *database.Database
+	location *time.Location
+
+	providerFees  map[string]models.FeeScheduleModel // by instance
+	instanceFees  map[string]models.FeeScheduleModel // our fee by instance
+	defaultFees   models.FeeScheduleModel
+	volumeEnabled bool
+}
+
+// NewEngine reads the fee schedules of every configured instance
+func NewEngine(db *database.Database, cfg *config.Config) (*Engine, error) {
+	location, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		db:            db,
+		location:      location,
+		providerFees:  map[string]models.FeeScheduleModel{},
+		instanceFees:  map[string]models.FeeScheduleModel{},
+		volumeEnabled: db != nil,
+	}
+
+	if err := parseSchedule(os.Getenv("MERCHANT_FEES"), &e.defaultFees); err != nil {
+		return nil, fmt.Errorf("invalid MERCHANT_FEES: %w", err)
+	}
+
+	for name, instance := range cfg.Instances {
+		var provider, ours models.FeeScheduleModel
+		if err := parseSchedule(instance.Get("PROVIDER_FEES"), &provider); err != nil {
+			return nil, fmt.Errorf("invalid %s_PROVIDER_FEES: %w", instance.Prefix, err)
+		}
+		e.providerFees[name] = provider
+
+		if raw := instance.Get("MERCHANT_FEES"); raw != "" {
+			if err := parseSchedule(raw, &ours); err != nil {
+				return nil, fmt.Errorf("invalid %s_MERCHANT_FEES: %w", instance.Prefix, err)
+			}
+			e.instanceFees[name] = ours
+		}
+	}
+
+	return e, nil
+}
+
+func parseSchedule(raw string, schedule *models.FeeScheduleModel) error {
+	if raw == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), schedule)
+}
+
+// Calculate returns the fees of a payment of amount made by merchant through the aggregator instance
+func (e *Engine) Calculate(merchant models.MerchantModel, aggregator string, amount float64) (models.FeesModel, error) {
+	since := monthStart(time.Now(), e.location)
+
+	providerSchedule := e.providerFees[aggregator]
+	providerVolume, err := e.volume(providerSchedule, bson.M{"aggregator": aggregator}, since)
+	if err != nil {
+		return models.FeesModel{}, err
+	}
+
+	schedule := e.scheduleFor(merchant, aggregator)
+	merchantVolume, err := e.volume(schedule, bson.M{"merchant_id": merchant.ID}, since)
+	if err != nil {
+		return models.FeesModel{}, err
+	}
+
+	providerFee := Apply(providerSchedule, amount, providerVolume)
+	fee := Apply(schedule, amount, merchantVolume)
+	return models.FeesModel{
+		Gross:       round(amount),
+		ProviderFee: providerFee,
+		Fee:         fee,
+		Net:         round(amount - fee),
+	}, nil
+}
+
+func (e *Engine) scheduleFor(merchant models.MerchantModel, aggregator string) models.FeeScheduleModel {
+	if schedule, ok := merchant.Fees[aggregator]; ok {
+		return schedule
+	}
+	if schedule, ok := merchant.Fees["default"]; ok {
+		return schedule
+	}
+	if schedule, ok := e.instanceFees[aggregator]; ok {
+		return schedule
+	}
+	return e.defaultFees
+}
+
+// volume is only looked up for tiered schedules
+func (e *Engine) volume(schedule models.FeeScheduleModel, filter bson.M, since time.Time) (float64, error) {
+	if len(schedule.Tiers) == 0 || !e.volumeEnabled {
+		return 0, nil
+	}
+	return e.db.DepositVolume(filter, since)
+}
+
+// Apply calculates the fee of one payment, volume being the monthly volume before it
+func Apply(schedule models.FeeScheduleModel, amount, volume float64) float64 {
+	fixed, percentage := schedule.Fixed, schedule.Percentage
+
+	best := -1.0
+	for _, tier := range schedule.Tiers {
+		if volume >= tier.FromVolume && tier.FromVolume > best {
+			best = tier.FromVolume
+			fixed, percentage = tier.Fixed, tier.Percentage
+		}
+	}
+
+	fee := fixed + amount*percentage/100
+	if schedule.Min > 0 && fee < schedule.Min {
+		fee = schedule.Min
+	}
+	if schedule.Max > 0 && fee > schedule.Max {
+		fee = schedule.Max
+	}
+	if fee > amount {
+		fee = amount
+	}
+	return round(fee)
+}
+
+// monthStart is the first instant of t's month in location
+func monthStart(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,139 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/report"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+)
+
+func TestApply(t *testing.T) {
+	tiered := models.FeeScheduleModel{
+		Fixed:      1,
+		Percentage: 2,
+		Tiers: []models.FeeTierModel{
+			{FromVolume: 10000, Fixed: 1, Percentage: 1.5},
+			{FromVolume: 50000, Fixed: 0, Percentage: 1},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		schedule models.FeeScheduleModel
+		amount   float64
+		volume   float64
+		want     float64
+	}{
+		{"no schedule", models.FeeScheduleModel{}, 100, 0, 0},
+		{"fixed only", models.FeeScheduleModel{Fixed: 2.5}, 100, 0, 2.5},
+		{"percentage only", models.FeeScheduleModel{Percentage: 1.5}, 200, 0, 3},
+		{"fixed and percentage", models.FeeScheduleModel{Fixed: 1, Percentage: 1.5}, 200, 0, 4},
+		{"rounded to cents", models.FeeScheduleModel{Percentage: 1.25}, 10.1, 0, 0.13},
+		{"raised to min", models.FeeScheduleModel{Percentage: 1, Min: 2}, 50, 0, 2},
+		{"capped at max", models.FeeScheduleModel{Percentage: 1, Max: 5}, 1000, 0, 5},
+		{"never more than the amount", models.FeeScheduleModel{Fixed: 5}, 3, 0, 3},
+		{"below the first tier", tiered, 100, 9999.99, 3},
+		{"at the first tier", tiered, 100, 10000, 2.5},
+		{"between tiers", tiered, 100, 30000, 2.5},
+		{"top tier", tiered, 100, 50000, 1},
+		{"min applies to tiers", models.FeeScheduleModel{Percentage: 2, Min: 1, Tiers: []models.FeeTierModel{{FromVolume: 0, Percentage: 0.5}}}, 100, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Apply(tt.schedule, tt.amount, tt.volume); got != tt.want {
+				t.Fatalf("Apply = %.2f, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTierOrder(t *testing.T) {
+	// tiers may be listed in any order, the highest one reached applies
+	schedule := models.FeeScheduleModel{Percentage: 3, Tiers: []models.FeeTierModel{
+		{FromVolume: 50000, Percentage: 1},
+		{FromVolume: 10000, Percentage: 2},
+	}}
+	if got := Apply(schedule, 100, 60000); got != 1 {
+		t.Fatalf("Apply = %.2f, want 1", got)
+	}
+	if got := Apply(schedule, 100, 20000); got != 2 {
+		t.Fatalf("Apply = %.2f, want 2", got)
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	istanbul, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{"middle of the month", time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, istanbul)},
+		// 22:30 UTC on the last day is already the 1st in Istanbul (UTC+3)
+		{"new month in Istanbul, old month in UTC", time.Date(2026, 3, 31, 22, 30, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, istanbul)},
+		{"server in another zone", time.Date(2026, 4, 30, 18, 0, 0, 0, time.FixedZone("PDT", -7*3600)), time.Date(2026, 5, 1, 0, 0, 0, 0, istanbul)},
+		{"first instant of the month", time.Date(2026, 1, 1, 0, 0, 0, 0, istanbul), time.Date(2026, 1, 1, 0, 0, 0, 0, istanbul)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := monthStart(tt.at, istanbul); !got.Equal(tt.want) {
+				t.Fatalf("monthStart = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleFor(t *testing.T) {
+	merchantInstance := models.FeeScheduleModel{Fixed: 1}
+	merchantDefault := models.FeeScheduleModel{Fixed: 2}
+	instance := models.FeeScheduleModel{Fixed: 3}
+	global := models.FeeScheduleModel{Fixed: 4}
+	e := &Engine{instanceFees: map[string]models.FeeScheduleModel{"brand_a": instance}, defaultFees: global}
+
+	tests := []struct {
+		name     string
+		merchant models.MerchantModel
+		instance string
+		want     models.FeeScheduleModel
+	}{
+		{"merchant schedule for the instance", models.MerchantModel{Fees: map[string]models.FeeScheduleModel{"brand_a": merchantInstance, "default": merchantDefault}}, "brand_a", merchantInstance},
+		{"merchant default", models.MerchantModel{Fees: map[string]models.FeeScheduleModel{"default": merchantDefault}}, "brand_a", merchantDefault},
+		{"instance schedule", models.MerchantModel{}, "brand_a", instance},
+		{"global schedule", models.MerchantModel{}, "sandbox", global},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.scheduleFor(tt.merchant, tt.instance); got.Fixed != tt.want.Fixed {
+				t.Fatalf("schedule fixed %.2f, want %.2f", got.Fixed, tt.want.Fixed)
+			}
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	t.Setenv("MERCHANT_FEES", `{"percentage": 2}`)
+	t.Setenv("BRAND_A_PROVIDER_FEES", `{"fixed": 1, "percentage": 0.5}`)
+	cfg := &config.Config{Instances: map[string]payment.InstanceConfig{
+		"brand_a": {Name: "brand_a", Type: "sansgetirsin", Prefix: "BRAND_A"},
+	}}
+	e, err := NewEngine(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.Calculate(models.MerchantModel{}, "brand_a", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.FeesModel{Gross: 1000, ProviderFee: 6, Fee: 20, Net: 980}
+	if got != want {
+		t.Fatalf("Calculate = %+v, want %+v", got, want)
+	}
+}
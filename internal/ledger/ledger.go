@@ -0,0 +1,151 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrUnbalanced is returned for a journal whose lines don't sum to zero
+var ErrUnbalanced = errors.New("journal does not balance")
+
+// Account names. Debits are positive and credits negative, so asset and expense
+// accounts have positive balances and liability and revenue accounts negative ones
+const (
+	FeeRevenue = "fee_revenue"
+	Payouts    = "manual_payouts" // money we paid out ourselves, e.g. refunds the provider can't do
+)
+
+// MerchantPayable is what we owe a merchant
+func MerchantPayable(merchantID primitive.ObjectID) string {
+	return "merchant_payable:" + merchantID.Hex()
+}
+
+// ProviderReceivable is what an aggregator instance holds for us
+func ProviderReceivable(aggregator string) string {
+	return "provider_receivable:" + aggregator
+}
+
+// ProviderFees is the commission an aggregator instance charged us
+func ProviderFees(aggregator string) string {
+	return "provider_fees:" + aggregator
+}
+
+// Post records the journal for a payment's status transition. Transitions that move
+// no money post nothing, and posting the same transition twice is a no-op
+func Post(db *database.Database, paymentDoc models.PaymentModel, from, to string) error {
+	lines := linesFor(paymentDoc, from, to)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	journal := models.JournalModel{
+		ID:          fmt.Sprintf("%s:%s:%s", paymentDoc.ID.Hex(), from, to),
+		PaymentID:   paymentDoc.ID,
+		MerchantID:  paymentDoc.MerchantID,
+		Description: fmt.Sprintf("%s %s: %s -> %s", paymentDoc.TransactionType, paymentDoc.TransactionID, from, to),
+		Lines:       lines,
+		PostedAt:    primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if sum(lines) != 0 {
+		return fmt.Errorf("%w: %s", ErrUnbalanced, journal.ID)
+	}
+
+	err := db.InsertJournal(journal)
+	if errors.Is(err, database.ErrDuplicate) {
+		logger.WarningLogger.Printf("Ledger: journal %s already posted", journal.ID)
+		return nil
+	}
+	return err
+}
+
+// Moves reports whether a payment's status transition posts a journal
+func Moves(paymentDoc models.PaymentModel, from, to string) bool {
+	return len(linesFor(paymentDoc, from, to)) > 0
+}
+
+// linesFor are the posting rules
+func linesFor(p models.PaymentModel, from, to string) []models.LedgerLineModel {
+	if to != models.StatusConfirmed {
+		return nil
+	}
+
+	switch p.TransactionType {
+	case models.TypeDeposit:
+		// the provider collected the gross amount and keeps its commission,
+		// we owe the merchant the net amount and earn our fee
+		fees := models.FeesModel{Gross: p.Amount, Net: p.Amount}
+		if p.Fees != nil {
+			fees = *p.Fees
+		}
+		return compact([]models.LedgerLineModel{
+			{Account: ProviderReceivable(p.Aggregator), Amount: round(fees.Gross - fees.ProviderFee)},
+			{Account: ProviderFees(p.Aggregator), Amount: round(fees.ProviderFee)},
+			{Account: MerchantPayable(p.MerchantID), Amount: -round(fees.Net)},
+			{Account: FeeRevenue, Amount: -round(fees.Fee)},
+		})
+
+	case models.TypeRefund:
+		// the refund is paid out of the merchant's balance, by the provider or by us
+		source := ProviderReceivable(p.Aggregator)
+		if from == models.StatusPendingPayout {
+			source = Payouts
+		}
+		return compact([]models.LedgerLineModel{
+			{Account: MerchantPayable(p.MerchantID), Amount: round(p.Amount)},
+			{Account: source, Amount: -round(p.Amount)},
+		})
+	}
+	return nil
+}
+
+// Balance returns an account's balance as of at
+func Balance(db *database.Database, account string, at time.Time) (float64, error) {
+	balance, err := db.AccountBalance(account, at)
+	return round(balance), err
+}
+
+// Check verifies that every journal, and so the whole ledger, sums to zero
+func Check(db *database.Database) ([]models.JournalModel, error) {
+	unbalanced, err := db.UnbalancedJournals()
+	if err != nil {
+		return nil, err
+	}
+	total, err := db.LedgerTotal()
+	if err != nil {
+		return nil, err
+	}
+	if len(unbalanced) > 0 || round(total) != 0 {
+		return unbalanced, fmt.Errorf("%w: %d unbalanced journals, ledger total %.2f", ErrUnbalanced, len(unbalanced), total)
+	}
+	return nil, nil
+}
+
+func compact(lines []models.LedgerLineModel) []models.LedgerLineModel {
+	kept := lines[:0]
+	for _, line := range lines {
+		if line.Amount != 0 {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+func sum(lines []models.LedgerLineModel) float64 {
+	total := 0.0
+	for _, line := range lines {
+		total += line.Amount
+	}
+	return round(total)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"testing"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLinesFor(t *testing.T) {
+	merchant := primitive.NewObjectID()
+	payable := MerchantPayable(merchant)
+	receivable := ProviderReceivable("brand_a")
+	providerFees := ProviderFees("brand_a")
+
+	deposit := models.PaymentModel{
+		MerchantID:      merchant,
+		Amount:          1000,
+		TransactionType: models.TypeDeposit,
+		Aggregator:      "brand_a",
+		Fees:            &models.FeesModel{Gross: 1000, ProviderFee: 6, Fee: 20, Net: 980},
+	}
+	noFees := deposit
+	noFees.Fees = nil
+	refund := models.PaymentModel{MerchantID: merchant, Amount: 250, TransactionType: models.TypeRefund, Aggregator: "brand_a"}
+
+	tests := []struct {
+		name    string
+		payment models.PaymentModel
+		from    string
+		to      string
+		want    map[string]float64
+	}{
+		{"deposit confirmed", deposit, models.StatusPending, models.StatusConfirmed, map[string]float64{
+			receivable: 994, providerFees: 6, payable: -980, FeeRevenue: -20,
+		}},
+		{"deposit without fees", noFees, models.StatusPending, models.StatusConfirmed, map[string]float64{
+			receivable: 1000, payable: -1000,
+		}},
+		{"deposit held for review", deposit, models.StatusPending, models.StatusHeld, nil},
+		{"held deposit approved", deposit, models.StatusHeld, models.StatusConfirmed, map[string]float64{
+			receivable: 994, providerFees: 6, payable: -980, FeeRevenue: -20,
+		}},
+		{"deposit failed", deposit, models.StatusPending, models.StatusFailed, nil},
+		{"deposit expired", deposit, models.StatusPending, models.StatusExpired, nil},
+		{"deposit partially refunded", deposit, models.StatusConfirmed, models.StatusPartiallyRefunded, nil},
+		{"refund through the provider", refund, models.StatusPending, models.StatusConfirmed, map[string]float64{
+			payable: 250, receivable: -250,
+		}},
+		{"refund paid out by hand", refund, models.StatusPendingPayout, models.StatusConfirmed, map[string]float64{
+			payable: 250, Payouts: -250,
+		}},
+		{"refund failed", refund, models.StatusPending, models.StatusFailed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := linesFor(tt.payment, tt.from, tt.to)
+			if got, want := Moves(tt.payment, tt.from, tt.to), len(tt.want) > 0; got != want {
+				t.Fatalf("Moves = %v, want %v", got, want)
+			}
+			if len(lines) != len(tt.want) {
+				t.Fatalf("%d lines %v, want %d", len(lines), lines, len(tt.want))
+			}
+			for _, line := range lines {
+				if want, ok := tt.want[line.Account]; !ok || line.Amount != want {
+					t.Errorf("%s: %.2f, want %.2f", line.Account, line.Amount, want)
+				}
+			}
+			if total := sum(lines); total != 0 {
+				t.Fatalf("journal sums to %.2f", total)
+			}
+		})
+	}
+}
+
+func TestLinesForRounding(t *testing.T) {
+	// fees rounded separately must still leave a balanced journal
+	deposit := models.PaymentModel{
+		MerchantID:      primitive.NewObjectID(),
+		Amount:          333.33,
+		TransactionType: models.TypeDeposit,
+		Aggregator:      "brand_a",
+		Fees:            &models.FeesModel{Gross: 333.33, ProviderFee: 1.67, Fee: 5, Net: 328.33},
+	}
+	if total := sum(linesFor(deposit, models.StatusPending, models.StatusConfirmed)); total != 0 {
+		t.Fatalf("journal sums to %.2f", total)
+	}
+}
@@ -0,0 +1,247 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	_ "time/tzdata" // business days need Europe/Istanbul even where the OS has no zone data
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/report"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Engine checks transactions against amount and count limits before they are made.
+//
+// Three scopes are checked, each with its own limits by transaction type:
+// the merchant (its own limits, else MERCHANT_LIMITS), the payer's IBAN as declared by
+// the merchant (IBAN_LIMITS) and the aggregator instance (<PREFIX>_LIMITS).
+// Limits in variables are JSON, e.g. {"deposit": {"max_amount": 50000, "daily_count": 20}}
+//
+// Daily and monthly limits are reserved on counters in Mongo, checked and taken in one
+// update, so concurrent transactions can't overshoot them together
+type Engine struct {
+	db       *database.Database
+	location *time.Location
+
+	merchantLimits map[string]models.TransactionLimitsModel
+	ibanLimits     map[string]models.TransactionLimitsModel
+	instanceLimits map[string]map[string]models.TransactionLimitsModel // by instance
+}
+
+// NewEngine reads the limits of every configured instance
+func NewEngine(db *database.Database, cfg *config.Config) (*Engine, error) {
+	location, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		db:             db,
+		location:       location,
+		instanceLimits: map[string]map[string]models.TransactionLimitsModel{},
+	}
+
+	if err := parseLimits(os.Getenv("MERCHANT_LIMITS"), &e.merchantLimits); err != nil {
+		return nil, fmt.Errorf("invalid MERCHANT_LIMITS: %w", err)
+	}
+	if err := parseLimits(os.Getenv("IBAN_LIMITS"), &e.ibanLimits); err != nil {
+		return nil, fmt.Errorf("invalid IBAN_LIMITS: %w", err)
+	}
+	for name, instance := range cfg.Instances {
+		var limits map[string]models.TransactionLimitsModel
+		if err := parseLimits(instance.Get("LIMITS"), &limits); err != nil {
+			return nil, fmt.Errorf("invalid %s_LIMITS: %w", instance.Prefix, err)
+		}
+		e.instanceLimits[name] = limits
+	}
+
+	return e, nil
+}
+
+func parseLimits(raw string, limits *map[string]models.TransactionLimitsModel) error {
+	if raw == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), limits)
+}
+
+// scope is one set of limits and the payments that count towards it
+type scope struct {
+	name   string // merchant, iban or aggregator, used in the rule and counter keys
+	key    string // who in the scope, e.g. the merchant's ID
+	label  string // used in the reason
+	limits models.TransactionLimitsModel
+	filter bson.M
+}
+
+// Reservation is what a transaction took from the limit counters
+type Reservation struct {
+	Keys   []string
+	Amount float64
+}
+
+// Reserve takes candidate's amount from the daily and monthly limits of merchant, the
+// payer's IBAN and the aggregator. It returns a *payment.Rejection, and reserves nothing,
+// when candidate would break a limit
+func (e *Engine) Reserve(merchant models.MerchantModel, candidate models.PaymentModel) (Reservation, error) {
+	scopes := e.scopes(merchant, candidate)
+	for _, s := range scopes {
+		if err := checkAmount(s, candidate); err != nil {
+			return Reservation{}, err
+		}
+	}
+
+	reservation := Reservation{Amount: candidate.Amount}
+	for _, s := range scopes {
+		keys, err := e.reserve(s, candidate)
+		reservation.Keys = append(reservation.Keys, keys...)
+		if err != nil {
+			e.Release(reservation)
+			return Reservation{}, err
+		}
+	}
+	return reservation, nil
+}
+
+// Release gives a reservation back, for transactions that were not made after all
+func (e *Engine) Release(reservation Reservation) {
+	for _, key := range reservation.Keys {
+		if err := e.db.ReleaseLimit(key, reservation.Amount); err != nil {
+			logger.ErrorLogger.Printf("Limits: failed to release %.2f on %s: %v", reservation.Amount, key, err)
+		}
+	}
+}
+
+// DepositCheck adapts Reserve to the deposit flow, reporting each reservation made
+// to reserved so the caller can keep or release it
+func (e *Engine) DepositCheck(merchant models.MerchantModel, reserved func(Reservation)) payment.DepositCheck {
+	return func(ctx context.Context, candidate models.PaymentModel) error {
+		reservation, err := e.Reserve(merchant, candidate)
+		if err != nil {
+			return err
+		}
+		reserved(reservation)
+		return nil
+	}
+}
+
+func (e *Engine) scopes(merchant models.MerchantModel, candidate models.PaymentModel) []scope {
+	merchantLimits, ok := merchant.TransactionLimits[candidate.TransactionType]
+	if !ok {
+		merchantLimits = e.merchantLimits[candidate.TransactionType]
+	}
+	scopes := []scope{{
+		name:   "merchant",
+		key:    merchant.ID.Hex(),
+		label:  "merchant",
+		limits: merchantLimits,
+		filter: bson.M{"merchant_id": merchant.ID},
+	}}
+	// the payer's own IBAN, not candidate.IBAN, which is the provider's account every payer sends to
+	if candidate.PayerIBAN != "" {
+		scopes = append(scopes, scope{
+			name:   "iban",
+			key:    candidate.PayerIBAN,
+			label:  "IBAN " + candidate.PayerIBAN,
+			limits: e.ibanLimits[candidate.TransactionType],
+			filter: bson.M{"payer_iban": candidate.PayerIBAN},
+		})
+	}
+	if candidate.Aggregator != "" {
+		scopes = append(scopes, scope{
+			name:   payment.ScopeAggregator,
+			key:    candidate.Aggregator,
+			label:  "aggregator " + candidate.Aggregator,
+			limits: e.instanceLimits[candidate.Aggregator][candidate.TransactionType],
+			filter: bson.M{"aggregator": candidate.Aggregator},
+		})
+	}
+	return scopes
+}
+
+// checkAmount checks the per transaction limits, which need no counter
+func checkAmount(s scope, candidate models.PaymentModel) error {
+	limits, amount := s.limits, candidate.Amount
+	if limits.MinAmount > 0 && amount < limits.MinAmount {
+		return rejection(s, "min_amount", "%.2f is below the %s minimum of %.2f per %s", amount, s.label, limits.MinAmount, candidate.TransactionType)
+	}
+	if limits.MaxAmount > 0 && amount > limits.MaxAmount {
+		return rejection(s, "max_amount", "%.2f is above the %s maximum of %.2f per %s", amount, s.label, limits.MaxAmount, candidate.TransactionType)
+	}
+	return nil
+}
+
+// period is a daily or monthly limit of a scope
+type period struct {
+	name   string
+	since  time.Time
+	until  time.Time
+	amount float64
+	count  int64
+}
+
+func (e *Engine) periods(limits models.TransactionLimitsModel, now time.Time) []period {
+	now = now.In(e.location)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, e.location)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, e.location)
+	return []period{
+		{"daily", day, day.AddDate(0, 0, 1), limits.DailyAmount, limits.DailyCount},
+		{"monthly", month, month.AddDate(0, 1, 0), limits.MonthlyAmount, limits.MonthlyCount},
+	}
+}
+
+// counterKey names the counter of a scope's period, e.g. merchant:<id>:deposit:daily:2026-03-01
+func counterKey(s scope, transactionType string, p period) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", s.name, s.key, transactionType, p.name, p.since.Format("2006-01-02"))
+}
+
+// reserve takes the candidate from every capped period of s, returning the counters taken
+// even when one of them refuses it
+func (e *Engine) reserve(s scope, candidate models.PaymentModel) ([]string, error) {
+	var keys []string
+	for _, p := range e.periods(s.limits, time.Now()) {
+		if p.amount <= 0 && p.count <= 0 {
+			continue
+		}
+		key := counterKey(s, candidate.TransactionType, p)
+		since := p.since
+		seed := func() (float64, int64, error) {
+			return e.db.TransactionTotals(candidate.TransactionType, s.filter, since)
+		}
+		// counters are kept a day past their period so late releases still find them
+		counter, err := e.db.ReserveLimit(key, candidate.Amount, p.amount, p.count, p.until.AddDate(0, 0, 1), seed)
+		switch {
+		case errors.Is(err, database.ErrLimitReached):
+			if p.amount > 0 && counter.Amount+candidate.Amount > p.amount+amountEpsilon {
+				return keys, rejection(s, p.name+"_amount", "%s %s limit of %.2f reached, %.2f already used", s.label, p.name, p.amount, counter.Amount)
+			}
+			return keys, rejection(s, p.name+"_count", "%s %s limit of %d %ss reached", s.label, p.name, p.count, candidate.TransactionType)
+		case err != nil:
+			return keys, fmt.Errorf("failed to reserve %s %s limit: %w", s.name, p.name, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// amountEpsilon absorbs float rounding when amounts are compared to caps
+const amountEpsilon = 0.005
+
+func rejection(s scope, limit, format string, args ...interface{}) *payment.Rejection {
+	r := &payment.Rejection{
+		Rule:   "limits." + s.name + "." + limit,
+		Reason: fmt.Sprintf(format, args...),
+	}
+	if s.name == payment.ScopeAggregator {
+		r.Scope = payment.ScopeAggregator
+	}
+	return r
+}
@@ -0,0 +1,175 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"payment-aggregator/internal/report"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testEngine(t *testing.T) *Engine {
+	t.Helper()
+	location, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Engine{
+		location:       location,
+		merchantLimits: map[string]models.TransactionLimitsModel{models.TypeDeposit: {MaxAmount: 1000}},
+		ibanLimits:     map[string]models.TransactionLimitsModel{models.TypeDeposit: {MaxAmount: 500}},
+		instanceLimits: map[string]map[string]models.TransactionLimitsModel{
+			"brand_a": {models.TypeDeposit: {MinAmount: 50, DailyAmount: 10000}},
+		},
+	}
+}
+
+func TestScopes(t *testing.T) {
+	e := testEngine(t)
+	merchant := models.MerchantModel{ID: primitive.NewObjectID()}
+	candidate := models.PaymentModel{
+		Amount:          100,
+		TransactionType: models.TypeDeposit,
+		IBAN:            "TR000000000000000000000001", // the provider's account
+		PayerIBAN:       "TR000000000000000000000002",
+		Aggregator:      "brand_a",
+	}
+
+	scopes := e.scopes(merchant, candidate)
+	if len(scopes) != 3 {
+		t.Fatalf("%d scopes, want 3", len(scopes))
+	}
+	want := []struct {
+		name   string
+		key    string
+		filter bson.M
+	}{
+		{"merchant", merchant.ID.Hex(), bson.M{"merchant_id": merchant.ID}},
+		{"iban", candidate.PayerIBAN, bson.M{"payer_iban": candidate.PayerIBAN}},
+		{"aggregator", "brand_a", bson.M{"aggregator": "brand_a"}},
+	}
+	for i, w := range want {
+		s := scopes[i]
+		if s.name != w.name || s.key != w.key || len(s.filter) != 1 {
+			t.Fatalf("scope %d = %s %s %v, want %s %s %v", i, s.name, s.key, s.filter, w.name, w.key, w.filter)
+		}
+		for k, v := range w.filter {
+			if s.filter[k] != v {
+				t.Fatalf("scope %s filter %v, want %v", s.name, s.filter, w.filter)
+			}
+		}
+	}
+
+	// without a declared payer IBAN there is no IBAN scope, whatever the provider's account
+	candidate.PayerIBAN = ""
+	for _, s := range e.scopes(merchant, candidate) {
+		if s.name == "iban" {
+			t.Fatalf("IBAN scope without a payer IBAN: %+v", s)
+		}
+	}
+}
+
+func TestMerchantLimitsOverrideDefaults(t *testing.T) {
+	e := testEngine(t)
+	own := models.MerchantModel{TransactionLimits: map[string]models.TransactionLimitsModel{models.TypeDeposit: {MaxAmount: 5000}}}
+	if got := e.scopes(own, models.PaymentModel{TransactionType: models.TypeDeposit})[0].limits.MaxAmount; got != 5000 {
+		t.Fatalf("max amount %.2f, want the merchant's own 5000", got)
+	}
+	if got := e.scopes(models.MerchantModel{}, models.PaymentModel{TransactionType: models.TypeDeposit})[0].limits.MaxAmount; got != 1000 {
+		t.Fatalf("max amount %.2f, want MERCHANT_LIMITS 1000", got)
+	}
+}
+
+func TestCheckAmount(t *testing.T) {
+	e := testEngine(t)
+	merchant := models.MerchantModel{ID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		payerIBAN string
+		wantRule  string
+		wantScope string
+	}{
+		{"within every limit", 100, "TR01", "", ""},
+		{"above the merchant maximum", 1500, "", "limits.merchant.max_amount", ""},
+		{"above the payer IBAN maximum", 600, "TR01", "limits.iban.max_amount", ""},
+		{"payer IBAN maximum without a payer IBAN", 600, "", "", ""},
+		{"below the aggregator minimum", 10, "", "limits.aggregator.min_amount", payment.ScopeAggregator},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := models.PaymentModel{Amount: tt.amount, TransactionType: models.TypeDeposit, PayerIBAN: tt.payerIBAN, Aggregator: "brand_a"}
+			var err error
+			for _, s := range e.scopes(merchant, candidate) {
+				if err = checkAmount(s, candidate); err != nil {
+					break
+				}
+			}
+
+			var rejection *payment.Rejection
+			switch {
+			case tt.wantRule == "" && err != nil:
+				t.Fatalf("rejected: %v", err)
+			case tt.wantRule != "" && !errors.As(err, &rejection):
+				t.Fatalf("err = %v, want rejection %s", err, tt.wantRule)
+			case tt.wantRule != "" && (rejection.Rule != tt.wantRule || rejection.Scope != tt.wantScope):
+				t.Fatalf("rejected by %s scope %q, want %s scope %q", rejection.Rule, rejection.Scope, tt.wantRule, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	e := testEngine(t)
+	limits := models.TransactionLimitsModel{DailyAmount: 100, MonthlyCount: 5}
+
+	tests := []struct {
+		name                    string
+		now                     time.Time
+		wantDay, wantMonth      string
+		wantDayEnd, wantMonthTo string
+	}{
+		{"middle of the month", time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC), "2026-03-15", "2026-03-01", "2026-03-16", "2026-04-01"},
+		// 22:30 UTC on the last day is already the 1st in Istanbul (UTC+3)
+		{"new day in Istanbul", time.Date(2026, 3, 31, 22, 30, 0, 0, time.UTC), "2026-04-01", "2026-04-01", "2026-04-02", "2026-05-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			periods := e.periods(limits, tt.now)
+			day, month := periods[0], periods[1]
+			if got := day.since.Format("2006-01-02"); got != tt.wantDay {
+				t.Fatalf("day starts %s, want %s", got, tt.wantDay)
+			}
+			if got := day.until.Format("2006-01-02"); got != tt.wantDayEnd {
+				t.Fatalf("day ends %s, want %s", got, tt.wantDayEnd)
+			}
+			if got := month.since.Format("2006-01-02"); got != tt.wantMonth {
+				t.Fatalf("month starts %s, want %s", got, tt.wantMonth)
+			}
+			if got := month.until.Format("2006-01-02"); got != tt.wantMonthTo {
+				t.Fatalf("month ends %s, want %s", got, tt.wantMonthTo)
+			}
+			if day.amount != 100 || month.count != 5 {
+				t.Fatalf("caps %.2f and %d, want 100 and 5", day.amount, month.count)
+			}
+		})
+	}
+}
+
+func TestCounterKey(t *testing.T) {
+	e := testEngine(t)
+	s := scope{name: "iban", key: "TR01"}
+	p := e.periods(models.TransactionLimitsModel{}, time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC))
+	if got, want := counterKey(s, models.TypeDeposit, p[0]), "iban:TR01:deposit:daily:2026-03-15"; got != want {
+		t.Fatalf("key %s, want %s", got, want)
+	}
+	if got, want := counterKey(s, models.TypeDeposit, p[1]), "iban:TR01:deposit:monthly:2026-03-01"; got != want {
+		t.Fatalf("key %s, want %s", got, want)
+	}
+}
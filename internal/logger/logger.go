@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"log"
 	"os"
 )
@@ -23,3 +24,10 @@ func InitLogger() {
 	WarningLogger = log.New(file, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
 	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 }
+
+// Discard sets up the loggers to write nowhere, for tests
+func Discard() {
+	InfoLogger = log.New(io.Discard, "", 0)
+	WarningLogger = log.New(io.Discard, "", 0)
+	ErrorLogger = log.New(io.Discard, "", 0)
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"payment-aggregator/internal/circuitbreaker"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// circuitCollector reads the circuit breakers at scrape time
+type circuitCollector struct {
+	state    *prometheus.Desc
+	failures *prometheus.Desc
+	rejected *prometheus.Desc
+}
+
+func init() {
+	prometheus.MustRegister(&circuitCollector{
+		state: prometheus.NewDesc(namespace+"_circuit_breaker_state",
+			"Circuit breaker state per aggregator instance: 0 closed, 1 open, 2 half-open.", []string{"aggregator"}, nil),
+		failures: prometheus.NewDesc(namespace+"_circuit_breaker_failures_total",
+			"Calls counted as failures by the circuit breaker.", []string{"aggregator"}, nil),
+		rejected: prometheus.NewDesc(namespace+"_circuit_breaker_rejections_total",
+			"Calls rejected while the circuit breaker was open.", []string{"aggregator"}, nil),
+	})
+}
+
+func (c *circuitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.failures
+	ch <- c.rejected
+}
+
+func (c *circuitCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range circuitbreaker.Snapshots() {
+		state := map[string]float64{"closed": 0, "open": 1, "half-open": 2}[s.State]
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, state, s.Name)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(s.Failures), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(s.Rejections), s.Name)
+	}
+}
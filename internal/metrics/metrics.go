@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "payment_aggregator"
+
+var (
+	// Flows counts deposit and withdrawal flows by aggregator instance and outcome
+	Flows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flows_total",
+		Help:      "Deposit and withdrawal flows by type, aggregator instance and outcome.",
+	}, []string{"type", "aggregator", "outcome"})
+
+	// ProviderRequests times every HTTP call made to an aggregator
+	ProviderRequests = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Latency of aggregator API calls by instance, endpoint and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"aggregator", "endpoint", "code"})
+
+	// Callbacks counts inbound aggregator callbacks by result
+	Callbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "callbacks_total",
+		Help:      "Inbound aggregator callbacks by result.",
+	}, []string{"result"})
+
+	// DBOperations times MongoDB commands
+	DBOperations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Latency of MongoDB commands by command and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "outcome"})
+
+	// Notifications counts outbound merchant notifications by result
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Outbound merchant notification deliveries by result.",
+	}, []string{"result"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome turns an error into the "success"/"failure" label value
+func Outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Transport times the requests it sends to an aggregator instance, labelled by URL path
+type Transport struct {
+	Aggregator string
+	Base       http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	ProviderRequests.WithLabelValues(t.Aggregator, req.URL.Path, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,49 @@
+package names
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// turkish folds the Turkish letters to their closest ASCII letter, İ and ı included,
+// so "IŞIK" and "ışık" both become "isik"
+var turkish = strings.NewReplacer(
+	"İ", "i", "I", "i", "ı", "i", "Ş", "s", "ş", "s", "Ğ", "g", "ğ", "g",
+	"Ü", "u", "ü", "u", "Ö", "o", "ö", "o", "Ç", "c", "ç", "c",
+	"Â", "a", "â", "a", "Î", "i", "î", "i", "Û", "u", "û", "u",
+)
+
+// Normalize folds a person's or bank's name for comparison: Turkish letters
+// to ASCII, lower case, punctuation dropped and spaces collapsed
+func Normalize(name string) string {
+	folded := strings.ToLower(turkish.Replace(name))
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// Match reports whether two names are the same person, ignoring word order and
+// a missing middle name: the shorter name's words, at least two, must all be in the longer one
+func Match(a, b string) bool {
+	wordsA, wordsB := strings.Fields(Normalize(a)), strings.Fields(Normalize(b))
+	if len(wordsA) > len(wordsB) {
+		wordsA, wordsB = wordsB, wordsA
+	}
+	if len(wordsA) == 0 {
+		return false
+	}
+	if len(wordsA) == 1 && len(wordsB) > 1 {
+		return false
+	}
+
+	sort.Strings(wordsB)
+	for _, word := range wordsA {
+		i := sort.SearchStrings(wordsB, word)
+		if i == len(wordsB) || wordsB[i] != word {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/metrics"
+	"payment-aggregator/internal/tracing"
+	"payment-aggregator/models"
+)
+
+var client = &http.Client{Transport: tracing.Transport(http.DefaultTransport), Timeout: 10 * time.Second}
+
+// Merchant posts the payment to the merchant's callback URL, or CALLBACK_URL
+func Merchant(ctx context.Context, merchant models.MerchantModel, paymentDoc models.PaymentModel) {
+	callbackURL := merchant.CallbackURL
+	if callbackURL == "" {
+		callbackURL = os.Getenv("CALLBACK_URL")
+	}
+	if callbackURL == "" {
+		return
+	}
+
+	// Encode the payment into JSON
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(paymentDoc)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to encode callback payload: %v", err)
+		return
+	}
+
+	// Send the POST request, carrying the payment's trace
+	req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, &buf)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to create callback request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.Notifications.WithLabelValues("error").Inc()
+		logger.ErrorLogger.Printf("Failed to POST callback: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		metrics.Notifications.WithLabelValues("delivered").Inc()
+	} else {
+		metrics.Notifications.WithLabelValues("rejected").Inc()
+	}
+
+	logger.InfoLogger.Printf("Callback POST to %s, response: %s", callbackURL, resp.Status)
+}
@@ -0,0 +1,131 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"time"
+
+	"payment-aggregator/internal/audit"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+)
+
+// Settings controls which payments are polled and how often
+type Settings struct {
+	Delay       time.Duration // how long a payment stays pending before it is polled
+	Interval    time.Duration // spacing after the first poll, doubled after each one
+	MaxInterval time.Duration // cap for the spacing
+	Tick        time.Duration // how often to look for payments due for a poll
+	BatchSize   int64
+}
+
+// SettingsFromEnv reads POLLER_* variables, falling back to defaults
+func SettingsFromEnv() Settings {
+	return Settings{
+		Delay:       envDuration("POLLER_DELAY", 10*time.Minute),
+		Interval:    envDuration("POLLER_INTERVAL", time.Minute),
+		MaxInterval: envDuration("POLLER_MAX_INTERVAL", time.Hour),
+		Tick:        envDuration("POLLER_TICK", 30*time.Second),
+		BatchSize:   50,
+	}
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// Poller asks aggregators for the status of payments stuck in pending
+// and applies it through the same transition path as callbacks
+type Poller struct {
+	db       *database.Database
+	cfg      *config.Config
+	settings Settings
+}
+
+func New(db *database.Database, cfg *config.Config, settings Settings) *Poller {
+	return &Poller{db: db, cfg: cfg, settings: settings}
+}
+
+// Run polls until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	logger.InfoLogger.Printf("Poller started, polling payments pending for more than %s", p.settings.Delay)
+	ticker := time.NewTicker(p.settings.Tick)
+	defer ticker.Stop()
+
+	for {
+		p.PollOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			logger.InfoLogger.Println("Poller stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollOnce polls every payment currently due
+func (p *Poller) PollOnce(ctx context.Context) {
+	payments, err := p.db.ListPaymentsToPoll(time.Now().Add(-p.settings.Delay), p.settings.BatchSize)
+	if err != nil {
+		logger.ErrorLogger.Printf("Poller: failed to list pending payments: %v", err)
+		return
+	}
+
+	for _, paymentDoc := range payments {
+		p.poll(ctx, paymentDoc)
+	}
+}
+
+func (p *Poller) poll(ctx context.Context, paymentDoc models.PaymentModel) {
+	attempts := paymentDoc.PollAttempts + 1
+	defer func() {
+		// exponential spacing, stops once the payment leaves pending
+		if err := p.db.SchedulePoll(paymentDoc.ID, attempts, time.Now().Add(p.backoff(attempts))); err != nil {
+			logger.ErrorLogger.Printf("Poller: failed to schedule next poll of %s: %v", paymentDoc.ID.Hex(), err)
+		}
+	}()
+
+	runner, err := factory.Instance(p.cfg, paymentDoc.Aggregator)
+	if err != nil {
+		logger.WarningLogger.Printf("Poller: payment %s: %v", paymentDoc.ID.Hex(), err)
+		return
+	}
+	querier, ok := runner.(payment.StatusQuerier)
+	if !ok {
+		return
+	}
+
+	update, err := querier.GetTransactionStatus(audit.WithPayment(ctx, paymentDoc.ID), paymentDoc.TransactionID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Poller: status query for %s failed: %v", paymentDoc.TransactionID, err)
+		return
+	}
+	if update.TransactionID == "" {
+		update.TransactionID = paymentDoc.TransactionID
+	}
+
+	_, _, err = transition.Apply(p.db, paymentDoc.Aggregator, update, "poller")
+	if err != nil && !errors.Is(err, database.ErrConflict) {
+		logger.ErrorLogger.Printf("Poller: failed to apply status of %s: %v", paymentDoc.TransactionID, err)
+	}
+}
+
+func (p *Poller) backoff(attempts int) time.Duration {
+	d := time.Duration(float64(p.settings.Interval) * math.Pow(2, float64(attempts-1)))
+	if d <= 0 || d > p.settings.MaxInterval {
+		return p.settings.MaxInterval
+	}
+	return d
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/logger"
+)
+
+// DefaultMaxConcurrent is the in-flight quota for merchants without their own,
+// read from RATE_LIMIT_DEFAULT_CONCURRENCY
+func DefaultMaxConcurrent() int {
+	if v, err := strconv.Atoi(os.Getenv("RATE_LIMIT_DEFAULT_CONCURRENCY")); err == nil && v > 0 {
+		return v
+	}
+	return 5
+}
+
+// Limit rate limits an authenticated merchant on one endpoint, using the merchant's
+// limit for that endpoint, then its "default" limit, then DefaultLimit.
+// It must run after auth.RequireMerchant
+func Limit(limiter Limiter, endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchant, ok := auth.MerchantFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, ok := merchant.RateLimits[endpoint]
+		if !ok {
+			limit, ok = merchant.RateLimits["default"]
+		}
+		if !ok {
+			limit = DefaultLimit()
+		}
+
+		allowed, wait, err := limiter.Allow(merchant.ID.Hex()+":"+endpoint, limit)
+		if err != nil {
+			// fail open, a broken limiter store should not take the API down
+			logger.ErrorLogger.Printf("Rate limiter error for merchant %s: %v", merchant.ID.Hex(), err)
+		} else if !allowed {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitConcurrency caps a merchant's in-flight requests on the wrapped handler.
+// It must run after auth.RequireMerchant
+func LimitConcurrency(concurrency Concurrency, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchant, ok := auth.MerchantFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		max := merchant.MaxConcurrent
+		if max <= 0 {
+			max = DefaultMaxConcurrent()
+		}
+
+		release, ok, err := concurrency.Acquire(merchant.ID.Hex(), max)
+		switch {
+		case err != nil:
+			// fail open like Limit
+			logger.ErrorLogger.Printf("Concurrency limiter error for merchant %s: %v", merchant.ID.Hex(), err)
+		case !ok:
+			tooManyRequests(w, time.Second)
+			return
+		default:
+			defer release()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
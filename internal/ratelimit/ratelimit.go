@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limiter takes a token from the bucket identified by key,
+// reporting how long to wait before retrying when none is left
+type Limiter interface {
+	Allow(key string, limit models.RateLimitModel) (bool, time.Duration, error)
+}
+
+// DefaultLimit is used for merchants and endpoints without their own limit,
+// read from RATE_LIMIT_DEFAULT_RPS and RATE_LIMIT_DEFAULT_BURST
+func DefaultLimit() models.RateLimitModel {
+	limit := models.RateLimitModel{RequestsPerSecond: 10, Burst: 20}
+	if v, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT_DEFAULT_RPS"), 64); err == nil && v > 0 {
+		limit.RequestsPerSecond = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("RATE_LIMIT_DEFAULT_BURST")); err == nil && v > 0 {
+		limit.Burst = v
+	}
+	return limit
+}
+
+// FromEnv picks the limiter backend from RATE_LIMIT_BACKEND:
+// "mongo" shares buckets between replicas, anything else keeps them in memory
+func FromEnv(db *database.Database) Limiter {
+	if os.Getenv("RATE_LIMIT_BACKEND") == "mongo" {
+		return NewMongoLimiter(db)
+	}
+	return NewMemoryLimiter()
+}
+
+// retryAfter is how long it takes to refill the missing part of one token
+func retryAfter(tokens float64, limit models.RateLimitModel) time.Duration {
+	if limit.RequestsPerSecond <= 0 {
+		return time.Minute
+	}
+	seconds := (1 - tokens) / limit.RequestsPerSecond
+	return time.Duration(math.Ceil(seconds*1000)) * time.Millisecond
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket will have refilled, after which it can be forgotten
+}
+
+// evictEvery is how often MemoryLimiter drops the buckets that have refilled
+const evictEvery = time.Minute
+
+// MemoryLimiter keeps token buckets in this process only
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastEvict time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: map[string]*bucket{}, lastEvict: time.Now()}
+}
+
+func (m *MemoryLimiter) Allow(key string, limit models.RateLimitModel) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastEvict) >= evictEvery {
+		m.evictLocked(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.RequestsPerSecond)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(refillTime(float64(limit.Burst)-b.tokens, limit))
+
+	if !allowed {
+		return false, retryAfter(b.tokens, limit), nil
+	}
+	return true, 0, nil
+}
+
+// evictLocked drops the buckets that have refilled since their last use, a new
+// bucket starts full so forgetting them changes nothing
+func (m *MemoryLimiter) evictLocked(now time.Time) {
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastEvict = now
+}
+
+// refillTime is how long it takes to refill the given number of tokens
+func refillTime(tokens float64, limit models.RateLimitModel) time.Duration {
+	if limit.RequestsPerSecond <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(tokens / limit.RequestsPerSecond * float64(time.Second))
+}
+
+// MongoLimiter keeps token buckets in the database so limits hold across replicas
+type MongoLimiter struct {
+	db *database.Database
+}
+
+func NewMongoLimiter(db *database.Database) *MongoLimiter {
+	return &MongoLimiter{db: db}
+}
+
+func (m *MongoLimiter) Allow(key string, limit models.RateLimitModel) (bool, time.Duration, error) {
+	allowed, tokens, err := m.db.TakeToken(key, limit.RequestsPerSecond, limit.Burst)
+	if err != nil || allowed {
+		return allowed, 0, err
+	}
+	return false, retryAfter(tokens, limit), nil
+}
+
+// Concurrency caps the number of in-flight requests per key
+type Concurrency interface {
+	// Acquire takes a slot for key if fewer than max are in use, release must be called when done
+	Acquire(key string, max int) (release func(), ok bool, err error)
+}
+
+// ConcurrencyFromEnv picks the same backend as FromEnv, so with RATE_LIMIT_BACKEND=mongo
+// the in-flight cap holds across replicas too
+func ConcurrencyFromEnv(db *database.Database) Concurrency {
+	if os.Getenv("RATE_LIMIT_BACKEND") == "mongo" {
+		return NewMongoConcurrency(db)
+	}
+	return NewMemoryConcurrency()
+}
+
+// MemoryConcurrency counts in-flight requests in this process only
+type MemoryConcurrency struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func NewMemoryConcurrency() *MemoryConcurrency {
+	return &MemoryConcurrency{inFlight: map[string]int{}}
+}
+
+func (c *MemoryConcurrency) Acquire(key string, max int) (func(), bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inFlight[key] >= max {
+		return nil, false, nil
+	}
+	c.inFlight[key]++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inFlight[key]--; c.inFlight[key] <= 0 {
+			delete(c.inFlight, key)
+		}
+	}, true, nil
+}
+
+// MongoConcurrency keeps in-flight slots in the database so the cap holds across replicas.
+// A slot is leased for RATE_LIMIT_CONCURRENCY_LEASE (default 2m), after which a replica
+// that crashed without releasing it no longer holds it
+type MongoConcurrency struct {
+	db    *database.Database
+	lease time.Duration
+}
+
+func NewMongoConcurrency(db *database.Database) *MongoConcurrency {
+	lease := 2 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("RATE_LIMIT_CONCURRENCY_LEASE")); err == nil && v > 0 {
+		lease = v
+	}
+	return &MongoConcurrency{db: db, lease: lease}
+}
+
+func (c *MongoConcurrency) Acquire(key string, max int) (func(), bool, error) {
+	holder := primitive.NewObjectID().Hex()
+	ok, err := c.db.AcquireSlot(key, holder, max, c.lease)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		if err := c.db.ReleaseSlot(key, holder); err != nil {
+			logger.ErrorLogger.Printf("Failed to release concurrency slot of %s, it frees up when its lease ends: %v", key, err)
+		}
+	}, true, nil
+}
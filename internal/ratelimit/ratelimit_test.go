@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"payment-aggregator/models"
+)
+
+func TestMemoryLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   models.RateLimitModel
+		calls   int
+		allowed int
+	}{
+		{"within burst", models.RateLimitModel{RequestsPerSecond: 1, Burst: 5}, 5, 5},
+		{"over burst", models.RateLimitModel{RequestsPerSecond: 1, Burst: 3}, 5, 3},
+		{"zero rate", models.RateLimitModel{Burst: 1}, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryLimiter()
+			allowed := 0
+			for i := 0; i < tt.calls; i++ {
+				ok, wait, err := m.Allow("key", tt.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					allowed++
+				} else if wait <= 0 {
+					t.Fatalf("refused without a Retry-After")
+				}
+			}
+			if allowed != tt.allowed {
+				t.Fatalf("allowed %d of %d, want %d", allowed, tt.calls, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterEvictsRefilledBuckets(t *testing.T) {
+	m := NewMemoryLimiter()
+	fast := models.RateLimitModel{RequestsPerSecond: 1000, Burst: 1}
+	slow := models.RateLimitModel{RequestsPerSecond: 0.001, Burst: 1}
+	m.Allow("fast", fast)
+	m.Allow("slow", slow)
+
+	m.mu.Lock()
+	m.evictLocked(time.Now().Add(time.Second))
+	_, fastKept := m.buckets["fast"]
+	_, slowKept := m.buckets["slow"]
+	m.mu.Unlock()
+
+	if fastKept {
+		t.Error("refilled bucket was kept")
+	}
+	if !slowKept {
+		t.Error("bucket still refilling was dropped")
+	}
+	if ok, _, _ := m.Allow("slow", slow); ok {
+		t.Error("empty bucket let a request through after eviction")
+	}
+}
+
+func TestMemoryConcurrency(t *testing.T) {
+	c := NewMemoryConcurrency()
+	release, ok, _ := c.Acquire("m", 1)
+	if !ok {
+		t.Fatal("first slot refused")
+	}
+	if _, ok, _ := c.Acquire("m", 1); ok {
+		t.Fatal("second slot given over the cap")
+	}
+	release()
+	if _, ok, _ := c.Acquire("m", 1); !ok {
+		t.Fatal("released slot not given back")
+	}
+	if len(c.inFlight) != 1 {
+		t.Fatalf("%d keys in flight, want 1", len(c.inFlight))
+	}
+}
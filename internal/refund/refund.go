@@ -0,0 +1,133 @@
+package refund
+
+import (
+	"errors"
+	"fmt"
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/factory"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidAmount = errors.New("refund amount must be positive")
+	ErrNotRefund     = errors.New("payment is not a refund awaiting completion")
+)
+
+// Service creates refunds as payments linked to the deposit they return money from
+type Service struct {
+	db  *database.Database
+	cfg *config.Config
+}
+
+func NewService(db *database.Database, cfg *config.Config) *Service {
+	return &Service{db: db, cfg: cfg}
+}
+
+// Refund returns amount of a merchant's confirmed deposit, the whole refundable
+// balance when amount is 0. The refund is sent through the aggregator when its adapter
+// implements payment.Refunder and is left for a manual payout otherwise
+func (s *Service) Refund(merchantID, paymentID primitive.ObjectID, amount float64, reason string) (models.PaymentModel, error) {
+	original, err := s.db.FindPayment(merchantID, paymentID)
+	if err != nil {
+		return models.PaymentModel{}, err
+	}
+
+	if amount == 0 {
+		amount = original.RefundableAmount()
+	}
+	if amount <= 0 {
+		return models.PaymentModel{}, ErrInvalidAmount
+	}
+
+	// reserve the amount on the deposit first, so concurrent refunds can't overdraw it
+	original, err = s.db.ReserveRefund(original.ID, amount)
+	if err != nil {
+		return models.PaymentModel{}, err
+	}
+
+	runner, err := factory.Instance(s.cfg, original.Aggregator)
+	if err != nil {
+		logger.WarningLogger.Printf("Refund of %s: aggregator %s unavailable, falling back to manual payout: %v", original.ID.Hex(), original.Aggregator, err)
+	}
+	refunder, canRefund := runner.(payment.Refunder)
+
+	refundDoc := models.PaymentModel{
+		ID:                primitive.NewObjectID(),
+		MerchantID:        original.MerchantID,
+		Amount:            amount,
+		Status:            models.StatusPending,
+		TransactionType:   models.TypeRefund,
+		PayerName:         original.PayerName,
+		IBAN:              original.IBAN,
+		BankName:          original.BankName,
+		Aggregator:        original.Aggregator,
+		OriginalPaymentID: original.ID,
+		Reason:            reason,
+	}
+	if !canRefund {
+		refundDoc.Status = models.StatusPendingPayout
+	}
+	if err := s.db.InsertPayment(&refundDoc); err != nil {
+		if _, err := s.db.ReleaseRefund(refundDoc); err != nil {
+			logger.ErrorLogger.Printf("Failed to release refunded amount %.2f on payment %s: %v", amount, original.ID.Hex(), err)
+		}
+		return models.PaymentModel{}, fmt.Errorf("failed to store refund: %w", err)
+	}
+
+	if !canRefund {
+		logger.InfoLogger.Printf("Refund %s: %s can't refund, waiting for a manual payout", refundDoc.ID.Hex(), original.Aggregator)
+		return refundDoc, nil
+	}
+
+	resp, err := refunder.Refund(original, amount)
+	if err != nil {
+		logger.ErrorLogger.Printf("Refund %s failed at %s: %v", refundDoc.ID.Hex(), original.Aggregator, err)
+		// moving the refund to failed gives the amount back to the deposit
+		s.setStatus(refundDoc, models.StatusFailed)
+		return models.PaymentModel{}, fmt.Errorf("refund failed: %w", err)
+	}
+
+	// callbacks and the poller find the refund by the aggregator's ID
+	if resp.TransactionID != "" {
+		stored, err := s.db.SetTransactionID(refundDoc, resp.TransactionID)
+		if err != nil {
+			refundDoc.TransactionID = resp.TransactionID
+			return refundDoc, fmt.Errorf("refund %s was made as %s but its transaction ID could not be stored: %w", refundDoc.ID.Hex(), resp.TransactionID, err)
+		}
+		refundDoc = stored
+	}
+	if resp.Status == models.StatusConfirmed {
+		return s.setStatus(refundDoc, models.StatusConfirmed)
+	}
+	return refundDoc, nil
+}
+
+// Complete records the outcome of a pending or manual refund. A failed refund gives
+// its amount back to the deposit's refundable balance, as it does through callbacks and polls
+func (s *Service) Complete(refundID primitive.ObjectID, status string) (models.PaymentModel, error) {
+	refundDoc, err := s.db.FindPaymentByID(refundID)
+	if err != nil {
+		return models.PaymentModel{}, err
+	}
+	if refundDoc.TransactionType != models.TypeRefund || !models.CanTransition(refundDoc.Status, status) {
+		return models.PaymentModel{}, ErrNotRefund
+	}
+
+	refundDoc, err = s.setStatus(refundDoc, status)
+	if err != nil {
+		return models.PaymentModel{}, err
+	}
+	return refundDoc, nil
+}
+
+func (s *Service) setStatus(refundDoc models.PaymentModel, status string) (models.PaymentModel, error) {
+	refundDoc, _, err := transition.To(s.db, refundDoc, status, "refund")
+	return refundDoc, err
+}
@@ -0,0 +1,229 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	_ "time/tzdata" // business days need Europe/Istanbul even where the OS has no zone data
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/models"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Timezone is where business days start and end
+const Timezone = "Europe/Istanbul"
+
+// Settlement is the payments of a date range grouped by business day,
+// aggregator instance, merchant, type and status, with totals
+type Settlement struct {
+	From   string `json:"from"` // first business day, YYYY-MM-DD
+	To     string `json:"to"`   // last business day, inclusive
+	Rows   []Row  `json:"rows"`
+	Totals Totals `json:"totals"`
+}
+
+type Row struct {
+	Day             string  `json:"day"`
+	Aggregator      string  `json:"aggregator"`
+	MerchantID      string  `json:"merchant_id"`
+	MerchantName    string  `json:"merchant_name"`
+	TransactionType string  `json:"transaction_type"`
+	Status          string  `json:"status"`
+	Count           int     `json:"count"`
+	Gross           float64 `json:"gross"`
+	ProviderFees    float64 `json:"provider_fees"`
+	Fees            float64 `json:"fees"`
+	Net             float64 `json:"net"`
+}
+
+// Totals are what settled in the report: confirmed deposits, including those since
+// refunded, less confirmed refunds. Pending, failed and expired rows are left out
+type Totals struct {
+	Count        int     `json:"count"`
+	Gross        float64 `json:"gross"`
+	ProviderFees float64 `json:"provider_fees"`
+	Fees         float64 `json:"fees"`
+	Net          float64 `json:"net"`
+}
+
+// ParseDay parses a YYYY-MM-DD business day
+func ParseDay(day string) (time.Time, error) {
+	location, err := time.LoadLocation(Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation("2006-01-02", day, location)
+}
+
+// Generate builds the settlement report of the business days from..to (inclusive).
+// A zero merchantID covers every merchant
+func Generate(db *database.Database, from, to time.Time, merchantID primitive.ObjectID) (Settlement, error) {
+	if to.Before(from) {
+		return Settlement{}, fmt.Errorf("report ends before it starts")
+	}
+
+	groups, err := db.SettlementGroups(from, to.AddDate(0, 0, 1), Timezone, merchantID)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	merchantNames := map[primitive.ObjectID]string{}
+	if merchants, err := db.ListMerchants(); err == nil {
+		for _, m := range merchants {
+			merchantNames[m.ID] = m.Name
+		}
+	}
+
+	settlement := Settlement{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Rows: []Row{}}
+	for _, g := range groups {
+		row := Row{
+			Day:             g.Day,
+			Aggregator:      g.Aggregator,
+			MerchantName:    merchantNames[g.MerchantID],
+			TransactionType: g.TransactionType,
+			Status:          g.Status,
+			Count:           g.Count,
+			Gross:           round(g.Gross),
+			ProviderFees:    round(g.ProviderFees),
+			Fees:            round(g.Fees),
+			Net:             round(g.Net),
+		}
+		if !g.MerchantID.IsZero() {
+			row.MerchantID = g.MerchantID.Hex()
+		}
+		settlement.Rows = append(settlement.Rows, row)
+	}
+	settlement.Totals = total(settlement.Rows)
+	return settlement, nil
+}
+
+// total sums the rows that settled, netting refunds off the deposits
+func total(rows []Row) Totals {
+	var totals Totals
+	for _, row := range rows {
+		sign := settledSign(row)
+		if sign == 0 {
+			continue
+		}
+		totals.Count += row.Count
+		totals.Gross = round(totals.Gross + sign*row.Gross)
+		totals.ProviderFees = round(totals.ProviderFees + sign*row.ProviderFees)
+		totals.Fees = round(totals.Fees + sign*row.Fees)
+		totals.Net = round(totals.Net + sign*row.Net)
+	}
+	return totals
+}
+
+// settledSign is 1 for rows of money received, -1 for money paid back and 0 for
+// rows that didn't settle
+func settledSign(row Row) float64 {
+	switch {
+	case row.TransactionType == models.TypeDeposit && (row.Status == models.StatusConfirmed ||
+		row.Status == models.StatusPartiallyRefunded || row.Status == models.StatusRefunded):
+		return 1
+	case row.TransactionType == models.TypeRefund && row.Status == models.StatusConfirmed:
+		return -1
+	}
+	return 0
+}
+
+var header = []string{"day", "aggregator", "merchant_id", "merchant_name", "transaction_type", "status", "count", "gross", "provider_fees", "fees", "net"}
+
+func (r Row) values() []interface{} {
+	return []interface{}{r.Day, r.Aggregator, r.MerchantID, r.MerchantName, r.TransactionType, r.Status, r.Count, r.Gross, r.ProviderFees, r.Fees, r.Net}
+}
+
+func (t Totals) values() []interface{} {
+	return []interface{}{"total", "", "", "", "", "", t.Count, t.Gross, t.ProviderFees, t.Fees, t.Net}
+}
+
+// WriteCSV writes the rows followed by a total line
+func (s Settlement) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for _, row := range s.Rows {
+		writer.Write(toStrings(row.values()))
+	}
+	writer.Write(toStrings(s.Totals.values()))
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteXLSX writes the rows followed by a total line to a single-sheet workbook
+func (s Settlement) WriteXLSX(w io.Writer) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := "Settlement"
+	f.SetSheetName("Sheet1", sheet)
+
+	writeRow := func(line int, values []interface{}) error {
+		cell, err := excelize.CoordinatesToCellName(1, line)
+		if err != nil {
+			return err
+		}
+		return f.SetSheetRow(sheet, cell, &values)
+	}
+
+	headerValues := make([]interface{}, len(header))
+	for i, h := range header {
+		headerValues[i] = h
+	}
+	if err := writeRow(1, headerValues); err != nil {
+		return err
+	}
+	for i, row := range s.Rows {
+		if err := writeRow(i+2, row.values()); err != nil {
+			return err
+		}
+	}
+	if err := writeRow(len(s.Rows)+2, s.Totals.values()); err != nil {
+		return err
+	}
+
+	_, err := f.WriteTo(w)
+	return err
+}
+
+// Write writes the report as "csv" or "xlsx"
+func (s Settlement) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return s.WriteCSV(w)
+	case "xlsx":
+		return s.WriteXLSX(w)
+	default:
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// ContentType returns the MIME type of a report format
+func ContentType(format string) string {
+	if format == "xlsx" {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+func toStrings(values []interface{}) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case float64:
+			out[i] = strconv.FormatFloat(v, 'f', 2, 64)
+		default:
+			out[i] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"testing"
+
+	"payment-aggregator/models"
+)
+
+func TestTotal(t *testing.T) {
+	deposits := func(status string, gross, providerFees, fees float64) Row {
+		return Row{TransactionType: models.TypeDeposit, Status: status, Count: 1, Gross: gross, ProviderFees: providerFees, Fees: fees, Net: gross - fees}
+	}
+	refunds := func(status string, amount float64) Row {
+		return Row{TransactionType: models.TypeRefund, Status: status, Count: 1, Gross: amount, Net: amount}
+	}
+
+	tests := []struct {
+		name string
+		rows []Row
+		want Totals
+	}{
+		{"no rows", nil, Totals{}},
+		{"confirmed deposits", []Row{deposits(models.StatusConfirmed, 1000, 6, 20)}, Totals{Count: 1, Gross: 1000, ProviderFees: 6, Fees: 20, Net: 980}},
+		{"pending, failed and expired left out", []Row{
+			deposits(models.StatusConfirmed, 1000, 6, 20),
+			deposits(models.StatusPending, 500, 3, 10),
+			deposits(models.StatusFailed, 700, 0, 0),
+			deposits(models.StatusExpired, 300, 0, 0),
+		}, Totals{Count: 1, Gross: 1000, ProviderFees: 6, Fees: 20, Net: 980}},
+		{"refunded deposits still settled", []Row{
+			deposits(models.StatusPartiallyRefunded, 1000, 6, 20),
+			deposits(models.StatusRefunded, 200, 1, 4),
+		}, Totals{Count: 2, Gross: 1200, ProviderFees: 7, Fees: 24, Net: 1176}},
+		{"confirmed refunds netted off", []Row{
+			deposits(models.StatusPartiallyRefunded, 1000, 6, 20),
+			refunds(models.StatusConfirmed, 250),
+		}, Totals{Count: 2, Gross: 750, ProviderFees: 6, Fees: 20, Net: 730}},
+		{"refunds not yet paid left out", []Row{
+			deposits(models.StatusConfirmed, 1000, 6, 20),
+			refunds(models.StatusPending, 100),
+			refunds(models.StatusPendingPayout, 100),
+			refunds(models.StatusFailed, 100),
+		}, Totals{Count: 1, Gross: 1000, ProviderFees: 6, Fees: 20, Net: 980}},
+		{"rounded to cents", []Row{
+			deposits(models.StatusConfirmed, 0.1, 0, 0),
+			deposits(models.StatusConfirmed, 0.2, 0, 0),
+		}, Totals{Count: 2, Gross: 0.3, Net: 0.3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := total(tt.rows); got != tt.want {
+				t.Fatalf("total = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
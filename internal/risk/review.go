@@ -0,0 +1,86 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/refund"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RejectedReason is the reason of the refund made for a rejected payment that was already paid
+const RejectedReason = "rejected in risk review"
+
+// Review records an operator's decision on a payment in the review queue.
+// Approving a held payment confirms it. Rejecting a payment that is still pending fails it,
+// one that was already paid is confirmed if it was held and refunded in full
+func Review(db *database.Database, refunds *refund.Service, id primitive.ObjectID, outcome, reviewer, note string) (models.PaymentModel, error) {
+	if outcome != models.ReviewApproved && outcome != models.ReviewRejected {
+		return models.PaymentModel{}, fmt.Errorf("unknown review outcome %q", outcome)
+	}
+
+	paymentDoc, err := db.SetRiskReview(id, models.RiskReviewModel{
+		Outcome:    outcome,
+		Reviewer:   reviewer,
+		Note:       note,
+		ReviewedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if err != nil {
+		return paymentDoc, err
+	}
+	logger.InfoLogger.Printf("Risk: payment %s %s by %s", id.Hex(), outcome, reviewer)
+
+	if outcome == models.ReviewApproved {
+		paymentDoc, err = release(db, paymentDoc)
+	} else {
+		paymentDoc, err = reject(db, refunds, paymentDoc)
+	}
+	if err != nil {
+		return paymentDoc, fmt.Errorf("review recorded but payment %s was not settled: %w", id.Hex(), err)
+	}
+	return paymentDoc, nil
+}
+
+// release confirms a held payment, booking it
+func release(db *database.Database, paymentDoc models.PaymentModel) (models.PaymentModel, error) {
+	if paymentDoc.Status != models.StatusHeld {
+		return paymentDoc, nil
+	}
+	updated, _, err := transition.To(db, paymentDoc, models.StatusConfirmed, "risk review")
+	return updated, err
+}
+
+// reject fails a payment that is still pending and gives back the money of one that was paid
+func reject(db *database.Database, refunds *refund.Service, paymentDoc models.PaymentModel) (models.PaymentModel, error) {
+	if models.CanTransition(paymentDoc.Status, models.StatusFailed) {
+		updated, _, err := transition.To(db, paymentDoc, models.StatusFailed, "risk review")
+		if !errors.Is(err, transition.ErrNotAllowed) {
+			return updated, err
+		}
+		// paid in the meantime
+		logger.WarningLogger.Printf("Risk: rejected payment %s was paid before it could be failed, refunding it", paymentDoc.ID.Hex())
+		paymentDoc = updated
+	}
+
+	// a held payment is booked first, the refund is booked against it
+	paymentDoc, err := release(db, paymentDoc)
+	if err != nil {
+		return paymentDoc, err
+	}
+	if paymentDoc.RefundableAmount() <= 0 {
+		return paymentDoc, nil
+	}
+
+	refundDoc, err := refunds.Refund(paymentDoc.MerchantID, paymentDoc.ID, 0, RejectedReason)
+	if err != nil {
+		return paymentDoc, fmt.Errorf("refund: %w", err)
+	}
+	logger.InfoLogger.Printf("Risk: rejected payment %s refunded by %s (%s)", paymentDoc.ID.Hex(), refundDoc.ID.Hex(), refundDoc.Status)
+	return db.FindPaymentByID(paymentDoc.ID)
+}
@@ -0,0 +1,195 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Input is what the rules see of a transaction about to be made
+type Input struct {
+	Merchant      models.MerchantModel
+	Candidate     models.PaymentModel
+	DeclaredPayer string // payer name the merchant sent, may be empty
+
+	// AllowedBy is the allowlist entry the transaction matched, if any. It exempts the
+	// transaction from rules that only score it, not from ones that deny it on their own
+	AllowedBy primitive.ObjectID
+}
+
+// Rule scores one kind of risk, returning nil when the transaction doesn't trigger it
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, in Input) (*models.RiskHitModel, error)
+}
+
+// RuleSettings configures a built-in rule, fields a rule doesn't use are ignored
+type RuleSettings struct {
+	Disabled bool     `json:"disabled,omitempty"`
+	Score    int      `json:"score,omitempty"`
+	Amount   float64  `json:"amount,omitempty"`
+	Count    int64    `json:"count,omitempty"`
+	Window   string   `json:"window,omitempty"` // duration, e.g. "10m"
+	Banks    []string `json:"banks,omitempty"`
+}
+
+// Engine scores transactions with its rules and decides whether to allow, review or deny them.
+//
+// The built-in rules are configured with RISK_RULES, JSON keyed by rule name, e.g.
+// {"new_iban_large_amount": {"score": 30, "amount": 10000}, "blocked_bank": {"banks": ["X Bank"]}}.
+// A score of RISK_REVIEW_SCORE (default 40) sends the transaction to review,
+// RISK_DENY_SCORE (default 100) denies it
+type Engine struct {
+	rules       []Rule
+	reviewScore int
+	denyScore   int
+}
+
+// NewEngine builds the built-in rules from the environment
+func NewEngine(db *database.Database) (*Engine, error) {
+	settings := defaultSettings()
+	if raw := os.Getenv("RISK_RULES"); raw != "" {
+		var configured map[string]RuleSettings
+		if err := json.Unmarshal([]byte(raw), &configured); err != nil {
+			return nil, fmt.Errorf("invalid RISK_RULES: %w", err)
+		}
+		for name, s := range configured {
+			if _, ok := settings[name]; !ok {
+				return nil, fmt.Errorf("invalid RISK_RULES: unknown rule %s", name)
+			}
+			settings[name] = merge(settings[name], s)
+		}
+	}
+
+	e := &Engine{
+		reviewScore: intFromEnv("RISK_REVIEW_SCORE", 40),
+		denyScore:   intFromEnv("RISK_DENY_SCORE", 100),
+	}
+	for _, name := range []string{RulePayerMismatch, RuleNewIBANLargeAmount, RuleRapidRepeat, RuleBlockedBank} {
+		s := settings[name]
+		if s.Disabled {
+			continue
+		}
+		rule, err := newRule(db, name, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RISK_RULES: %s: %w", name, err)
+		}
+		e.Add(rule)
+	}
+	return e, nil
+}
+
+// Add appends a rule to the ones every transaction is scored with
+func (e *Engine) Add(rule Rule) {
+	e.rules = append(e.rules, rule)
+}
+
+// Assess scores a transaction with every rule. A rule that can't be evaluated
+// sends the transaction to review rather than letting it through unchecked
+func (e *Engine) Assess(ctx context.Context, in Input) models.RiskModel {
+	assessment := models.RiskModel{
+		DeclaredPayer: in.DeclaredPayer,
+		AllowedBy:     in.AllowedBy,
+		AssessedAt:    primitive.NewDateTimeFromTime(time.Now()),
+	}
+	failed := false
+	for _, rule := range e.rules {
+		hit, err := rule.Evaluate(ctx, in)
+		if err != nil {
+			logger.ErrorLogger.Printf("Risk: rule %s failed: %v", rule.Name(), err)
+			hit, failed = &models.RiskHitModel{Rule: rule.Name(), Reason: "could not be evaluated: " + err.Error()}, true
+		}
+		if hit != nil {
+			assessment.Score += hit.Score
+			assessment.Hits = append(assessment.Hits, *hit)
+		}
+	}
+
+	assessment.Decision = e.decide(assessment, failed)
+	return assessment
+}
+
+// decide is the decision on an assessment. An allowlisted transaction is only denied by a
+// rule whose score reaches the deny score on its own, and is sent to review when a rule
+// could not be evaluated, as that rule might have denied it
+func (e *Engine) decide(assessment models.RiskModel, failed bool) string {
+	if !assessment.AllowedBy.IsZero() {
+		for _, hit := range assessment.Hits {
+			if hit.Score >= e.denyScore {
+				return models.RiskDeny
+			}
+		}
+		if failed {
+			return models.RiskReview
+		}
+		return models.RiskAllow
+	}
+
+	switch {
+	case assessment.Score >= e.denyScore:
+		return models.RiskDeny
+	case assessment.Score >= e.reviewScore || failed:
+		return models.RiskReview
+	default:
+		return models.RiskAllow
+	}
+}
+
+// Check assesses a transaction, returning a *payment.Rejection along with the
+// assessment when it is denied
+func (e *Engine) Check(ctx context.Context, in Input) (models.RiskModel, error) {
+	assessment := e.Assess(ctx, in)
+	if assessment.Decision != models.RiskDeny {
+		return assessment, nil
+	}
+
+	rules := make([]string, 0, len(assessment.Hits))
+	for _, hit := range assessment.Hits {
+		rules = append(rules, hit.Rule)
+	}
+	logger.WarningLogger.Printf("Risk: denied %s of %.2f for merchant %s on %s, score %d (%s)",
+		in.Candidate.TransactionType, in.Candidate.Amount, in.Merchant.ID.Hex(), in.Candidate.Aggregator, assessment.Score, strings.Join(rules, ", "))
+	return assessment, &payment.Rejection{
+		Rule:   "risk.deny",
+		Reason: fmt.Sprintf("%s refused by risk rules: %s", in.Candidate.TransactionType, strings.Join(rules, ", ")),
+	}
+}
+
+func intFromEnv(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// merge overrides the defaults with the configured fields that are set
+func merge(s, configured RuleSettings) RuleSettings {
+	s.Disabled = configured.Disabled
+	if configured.Score != 0 {
+		s.Score = configured.Score
+	}
+	if configured.Amount != 0 {
+		s.Amount = configured.Amount
+	}
+	if configured.Count != 0 {
+		s.Count = configured.Count
+	}
+	if configured.Window != "" {
+		s.Window = configured.Window
+	}
+	if configured.Banks != nil {
+		s.Banks = configured.Banks
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package risk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDecide(t *testing.T) {
+	e := &Engine{reviewScore: 40, denyScore: 100}
+	entry := primitive.NewObjectID()
+	assessment := func(allowedBy primitive.ObjectID, scores ...int) models.RiskModel {
+		a := models.RiskModel{AllowedBy: allowedBy}
+		for _, score := range scores {
+			a.Score += score
+			a.Hits = append(a.Hits, models.RiskHitModel{Score: score})
+		}
+		return a
+	}
+
+	tests := []struct {
+		name       string
+		assessment models.RiskModel
+		failed     bool
+		want       string
+	}{
+		{"no hits", assessment(primitive.NilObjectID), false, models.RiskAllow},
+		{"below review", assessment(primitive.NilObjectID, 30), false, models.RiskAllow},
+		{"review", assessment(primitive.NilObjectID, 40), false, models.RiskReview},
+		{"rule failed", assessment(primitive.NilObjectID), true, models.RiskReview},
+		{"scores add up to deny", assessment(primitive.NilObjectID, 50, 50), false, models.RiskDeny},
+		{"allowlisted review", assessment(entry, 40), false, models.RiskAllow},
+		{"allowlisted scores add up", assessment(entry, 50, 50), false, models.RiskAllow},
+		{"allowlisted deny rule", assessment(entry, 30, 100), false, models.RiskDeny},
+		{"allowlisted rule failed", assessment(entry), true, models.RiskReview},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.decide(tt.assessment, tt.failed); got != tt.want {
+				t.Errorf("decide() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIBANRulesWithoutPayerIBAN(t *testing.T) {
+	// the provider's receiving account is shared by every payer, only the payer's own IBAN is checked
+	in := Input{Candidate: models.PaymentModel{Amount: 50000, TransactionType: models.TypeDeposit, IBAN: "TR330006100519786457841326"}}
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{RuleNewIBANLargeAmount, newIBANLargeAmount{score: 30, amount: 10000}},
+		{RuleRapidRepeat, rapidRepeat{score: 50, count: 1, window: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit, err := tt.rule.Evaluate(context.Background(), in)
+			if err != nil || hit != nil {
+				t.Fatalf("Evaluate() = %v, %v, want no hit", hit, err)
+			}
+		})
+	}
+}
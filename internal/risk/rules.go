@@ -0,0 +1,140 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/names"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// built-in rule names, as used in RISK_RULES and recorded on hits
+const (
+	RulePayerMismatch      = "payer_mismatch"
+	RuleNewIBANLargeAmount = "new_iban_large_amount"
+	RuleRapidRepeat        = "rapid_repeat"
+	RuleBlockedBank        = "blocked_bank"
+)
+
+func defaultSettings() map[string]RuleSettings {
+	return map[string]RuleSettings{
+		RulePayerMismatch:      {Score: 40},
+		RuleNewIBANLargeAmount: {Score: 30, Amount: 10000},
+		RuleRapidRepeat:        {Score: 50, Count: 3, Window: "10m"},
+		RuleBlockedBank:        {Score: 100},
+	}
+}
+
+func newRule(db *database.Database, name string, s RuleSettings) (Rule, error) {
+	switch name {
+	case RulePayerMismatch:
+		return payerMismatch{score: s.Score}, nil
+	case RuleNewIBANLargeAmount:
+		return newIBANLargeAmount{db: db, score: s.Score, amount: s.Amount}, nil
+	case RuleRapidRepeat:
+		window, err := time.ParseDuration(s.Window)
+		if err != nil {
+			return nil, err
+		}
+		return rapidRepeat{db: db, score: s.Score, count: s.Count, window: window}, nil
+	case RuleBlockedBank:
+		banks := map[string]bool{}
+		for _, bank := range s.Banks {
+			banks[names.Normalize(bank)] = true
+		}
+		return blockedBank{score: s.Score, banks: banks}, nil
+	}
+	return nil, fmt.Errorf("unknown rule")
+}
+
+// payerMismatch scores a payer name the merchant sent that isn't the account holder's
+type payerMismatch struct {
+	score int
+}
+
+func (r payerMismatch) Name() string { return RulePayerMismatch }
+
+func (r payerMismatch) Evaluate(ctx context.Context, in Input) (*models.RiskHitModel, error) {
+	if in.DeclaredPayer == "" || in.Candidate.PayerName == "" || names.Match(in.DeclaredPayer, in.Candidate.PayerName) {
+		return nil, nil
+	}
+	return &models.RiskHitModel{
+		Rule:   r.Name(),
+		Score:  r.score,
+		Reason: fmt.Sprintf("payer %q is not account holder %q", in.DeclaredPayer, in.Candidate.PayerName),
+	}, nil
+}
+
+// newIBANLargeAmount scores a large amount from a payer IBAN we have never seen a payment from
+type newIBANLargeAmount struct {
+	db     *database.Database
+	score  int
+	amount float64
+}
+
+func (r newIBANLargeAmount) Name() string { return RuleNewIBANLargeAmount }
+
+func (r newIBANLargeAmount) Evaluate(ctx context.Context, in Input) (*models.RiskHitModel, error) {
+	if in.Candidate.PayerIBAN == "" || in.Candidate.Amount < r.amount {
+		return nil, nil
+	}
+	_, count, err := r.db.TransactionTotals(in.Candidate.TransactionType, bson.M{"payer_iban": in.Candidate.PayerIBAN}, time.Time{})
+	if err != nil || count > 0 {
+		return nil, err
+	}
+	return &models.RiskHitModel{
+		Rule:   r.Name(),
+		Score:  r.score,
+		Reason: fmt.Sprintf("first %s from payer IBAN %s is %.2f, at least %.2f", in.Candidate.TransactionType, in.Candidate.PayerIBAN, in.Candidate.Amount, r.amount),
+	}, nil
+}
+
+// rapidRepeat scores the same merchant taking the same amount from the same payer IBAN
+// count times within window
+type rapidRepeat struct {
+	db     *database.Database
+	score  int
+	count  int64
+	window time.Duration
+}
+
+func (r rapidRepeat) Name() string { return RuleRapidRepeat }
+
+func (r rapidRepeat) Evaluate(ctx context.Context, in Input) (*models.RiskHitModel, error) {
+	if in.Candidate.PayerIBAN == "" {
+		return nil, nil
+	}
+	filter := bson.M{"merchant_id": in.Merchant.ID, "amount": in.Candidate.Amount, "payer_iban": in.Candidate.PayerIBAN}
+	_, count, err := r.db.TransactionTotals(in.Candidate.TransactionType, filter, time.Now().Add(-r.window))
+	if err != nil || count+1 < r.count {
+		return nil, err
+	}
+	return &models.RiskHitModel{
+		Rule:   r.Name(),
+		Score:  r.score,
+		Reason: fmt.Sprintf("%d %ss of %.2f from payer IBAN %s within %s", count+1, in.Candidate.TransactionType, in.Candidate.Amount, in.Candidate.PayerIBAN, r.window),
+	}, nil
+}
+
+// blockedBank scores accounts at banks we don't take payments through
+type blockedBank struct {
+	score int
+	banks map[string]bool // normalized names
+}
+
+func (r blockedBank) Name() string { return RuleBlockedBank }
+
+func (r blockedBank) Evaluate(ctx context.Context, in Input) (*models.RiskHitModel, error) {
+	if !r.banks[names.Normalize(in.Candidate.BankName)] {
+		return nil, nil
+	}
+	return &models.RiskHitModel{
+		Rule:   r.Name(),
+		Score:  r.score,
+		Reason: fmt.Sprintf("bank %s is blocked", in.Candidate.BankName),
+	}, nil
+}
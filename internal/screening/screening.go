@@ -0,0 +1,200 @@
+package screening
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/names"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Normalize returns the form a value of kind is matched in: IBANs upper case without
+// spaces, payer and bank names folded with names.Normalize
+func Normalize(kind, value string) (string, error) {
+	var normalized string
+	switch kind {
+	case models.ListKindIBAN:
+		normalized = strings.ToUpper(strings.Join(strings.Fields(value), ""))
+	case models.ListKindPayer, models.ListKindBank:
+		normalized = names.Normalize(value)
+	default:
+		return "", fmt.Errorf("unknown kind %q, expected iban, payer or bank", kind)
+	}
+	if normalized == "" {
+		return "", fmt.Errorf("empty %s", kind)
+	}
+	return normalized, nil
+}
+
+// NewEntry validates and normalizes an entry before it is added to a list
+func NewEntry(list, kind, value, reason, createdBy string, expiresAt time.Time) (models.ListEntryModel, error) {
+	if list != models.ListBlock && list != models.ListAllow {
+		return models.ListEntryModel{}, fmt.Errorf("unknown list %q, expected block or allow", list)
+	}
+	normalized, err := Normalize(kind, value)
+	if err != nil {
+		return models.ListEntryModel{}, err
+	}
+	if strings.TrimSpace(reason) == "" {
+		return models.ListEntryModel{}, fmt.Errorf("a reason is required")
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return models.ListEntryModel{}, fmt.Errorf("expiry %s is in the past", expiresAt.Format(time.RFC3339))
+	}
+
+	entry := models.ListEntryModel{
+		List:       list,
+		Kind:       kind,
+		Value:      value,
+		Normalized: normalized,
+		Reason:     reason,
+		CreatedBy:  createdBy,
+		CreatedAt:  primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if !expiresAt.IsZero() {
+		entry.ExpiresAt = primitive.NewDateTimeFromTime(expiresAt)
+	}
+	return entry, nil
+}
+
+// Result is what the lists say about a transaction that isn't blocked
+type Result struct {
+	Allowed *models.ListEntryModel // the allowlist entry it matched, if any
+}
+
+// DefaultCacheTTL is how long the active entries are kept when LIST_CACHE_TTL is not set
+const DefaultCacheTTL = 30 * time.Second
+
+// CacheTTLFromEnv reads LIST_CACHE_TTL, DefaultCacheTTL otherwise
+func CacheTTLFromEnv() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("LIST_CACHE_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultCacheTTL
+}
+
+// Screener matches transactions against the blocklist and allowlist. The active entries
+// are loaded at most once per cache TTL, and again as soon as one of them expires
+type Screener struct {
+	db  *database.Database
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries []models.ListEntryModel
+	until   time.Time // when entries must be loaded again
+}
+
+func NewScreener(db *database.Database) *Screener {
+	return &Screener{db: db, ttl: CacheTTLFromEnv()}
+}
+
+// activeEntries returns the active entries, loading them when the cache is stale
+func (s *Screener) activeEntries() ([]models.ListEntryModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(s.until) {
+		return s.entries, nil
+	}
+	entries, err := s.db.ListEntries(database.ListEntryFilter{})
+	if err != nil {
+		return nil, err
+	}
+	s.entries, s.until = entries, cacheUntil(entries, now.Add(s.ttl))
+	return entries, nil
+}
+
+// cacheUntil is until, or the first expiry of entries when that is earlier
+func cacheUntil(entries []models.ListEntryModel, until time.Time) time.Time {
+	for _, entry := range entries {
+		if entry.ExpiresAt != 0 && entry.ExpiresAt.Time().Before(until) {
+			until = entry.ExpiresAt.Time()
+		}
+	}
+	return until
+}
+
+// Check matches a transaction's IBAN, account holder, declared payer and bank against
+// the active list entries. A blocklist match returns a *payment.Rejection and wins over
+// any allowlist match. Allowlist entries only match what the provider returned, never
+// the payer the merchant declared
+func (s *Screener) Check(candidate models.PaymentModel, declaredPayer string) (Result, error) {
+	entries, err := s.activeEntries()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to load blocklist: %w", err)
+	}
+
+	var result Result
+	for i, entry := range entries {
+		if !matches(entry, candidate, declaredPayer) {
+			continue
+		}
+		if entry.List == models.ListBlock {
+			logger.WarningLogger.Printf("Screening: %s of %.2f for merchant %s blocked by entry %s (%s %s: %s)",
+				candidate.TransactionType, candidate.Amount, candidate.MerchantID.Hex(), entry.ID.Hex(), entry.Kind, entry.Value, entry.Reason)
+			return Result{}, &payment.Rejection{
+				Rule:   "blocklist." + entry.Kind,
+				Reason: fmt.Sprintf("%s refused: %s is blocked", candidate.TransactionType, kindLabel(entry.Kind)),
+			}
+		}
+		if result.Allowed == nil {
+			result.Allowed = &entries[i]
+		}
+	}
+	return result, nil
+}
+
+// DepositCheck adapts Check to the deposit flow, calling allowed with the allowlist
+// entry each candidate matched, nil if none
+func (s *Screener) DepositCheck(declaredPayer string, allowed func(*models.ListEntryModel)) payment.DepositCheck {
+	return func(ctx context.Context, candidate models.PaymentModel) error {
+		result, err := s.Check(candidate, declaredPayer)
+		if err != nil {
+			return err
+		}
+		allowed(result.Allowed)
+		return nil
+	}
+}
+
+func matches(entry models.ListEntryModel, candidate models.PaymentModel, declaredPayer string) bool {
+	switch entry.Kind {
+	case models.ListKindIBAN:
+		// the payer's own IBAN is declared by the merchant, like the payer's name it can only block
+		return ibanMatches(entry, candidate.IBAN) ||
+			(entry.List == models.ListBlock && ibanMatches(entry, candidate.PayerIBAN))
+	case models.ListKindPayer:
+		// anyone can declare a trusted payer, only the account holder can let a payment through
+		return names.Match(entry.Normalized, candidate.PayerName) ||
+			(entry.List == models.ListBlock && names.Match(entry.Normalized, declaredPayer))
+	case models.ListKindBank:
+		return names.Normalize(candidate.BankName) == entry.Normalized
+	}
+	return false
+}
+
+func ibanMatches(entry models.ListEntryModel, iban string) bool {
+	normalized, _ := Normalize(models.ListKindIBAN, iban)
+	return normalized != "" && normalized == entry.Normalized
+}
+
+func kindLabel(kind string) string {
+	switch kind {
+	case models.ListKindIBAN:
+		return "the IBAN"
+	case models.ListKindPayer:
+		return "the payer"
+	default:
+		return "the bank"
+	}
+}
@@ -0,0 +1,72 @@
+package screening
+
+import (
+	"testing"
+	"time"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatches(t *testing.T) {
+	entry := func(list, kind, value string) models.ListEntryModel {
+		normalized, err := Normalize(kind, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return models.ListEntryModel{List: list, Kind: kind, Normalized: normalized}
+	}
+	candidate := models.PaymentModel{PayerName: "Ayşe Yılmaz", IBAN: "TR33 0006 1005 1978 6457 8413 26", BankName: "Example Bank",
+		PayerIBAN: "TR320010009999901234567890"}
+
+	tests := []struct {
+		name     string
+		entry    models.ListEntryModel
+		declared string
+		want     bool
+	}{
+		{"blocked account holder", entry(models.ListBlock, models.ListKindPayer, "AYSE YILMAZ"), "", true},
+		{"blocked declared payer", entry(models.ListBlock, models.ListKindPayer, "Mehmet Demir"), "Mehmet Demir", true},
+		{"allowed account holder", entry(models.ListAllow, models.ListKindPayer, "Yılmaz Ayşe"), "", true},
+		{"allowed declared payer only", entry(models.ListAllow, models.ListKindPayer, "Mehmet Demir"), "Mehmet Demir", false},
+		{"IBAN without spaces", entry(models.ListAllow, models.ListKindIBAN, "tr330006100519786457841326"), "", true},
+		{"blocked payer IBAN", entry(models.ListBlock, models.ListKindIBAN, "TR32 0010 0099 9990 1234 5678 90"), "", true},
+		{"allowed payer IBAN", entry(models.ListAllow, models.ListKindIBAN, "TR320010009999901234567890"), "", false},
+		{"other IBAN", entry(models.ListBlock, models.ListKindIBAN, "TR000000000000000000000000"), "", false},
+		{"bank", entry(models.ListBlock, models.ListKindBank, "EXAMPLE BANK"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(tt.entry, candidate, tt.declared); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheUntil(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	until := now.Add(DefaultCacheTTL)
+	expiring := func(d time.Duration) models.ListEntryModel {
+		return models.ListEntryModel{ExpiresAt: primitive.NewDateTimeFromTime(now.Add(d))}
+	}
+
+	tests := []struct {
+		name    string
+		entries []models.ListEntryModel
+		want    time.Time
+	}{
+		{"no entries", nil, until},
+		{"never expire", []models.ListEntryModel{{}, {}}, until},
+		{"expires later", []models.ListEntryModel{expiring(time.Hour)}, until},
+		{"expires sooner", []models.ListEntryModel{{}, expiring(time.Hour), expiring(10 * time.Second)}, now.Add(10 * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheUntil(tt.entries, until); !got.Equal(tt.want) {
+				t.Errorf("cacheUntil() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
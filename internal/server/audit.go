@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePaymentAudit returns the requests sent to aggregators for a payment and their responses
+func handlePaymentAudit(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		paymentDoc, err := db.FindPaymentByID(id)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to find payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to find payment")
+			return
+		}
+
+		records, err := db.ListPaymentAudit(paymentDoc)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list audit records of payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to list audit records")
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
+
+// handleCorrelationAudit returns the requests sent to aggregators under ?correlation_id=,
+// e.g. those of a deposit that failed and was never stored
+func handleCorrelationAudit(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := r.URL.Query().Get("correlation_id")
+		if correlationID == "" {
+			writeError(w, http.StatusBadRequest, "correlation_id is required")
+			return
+		}
+
+		records, err := db.ListCorrelationAudit(correlationID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list audit records of correlation %s: %v", correlationID, err)
+			writeError(w, http.StatusInternalServerError, "failed to list audit records")
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"payment-aggregator/internal/callback"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// limitFromQuery reads ?limit=, capped at 1000
+func limitFromQuery(r *http.Request, def int64) int64 {
+	if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 && v <= 1000 {
+		return v
+	}
+	return def
+}
+
+// handleListCallbacks lists stored callbacks, ?result=error,unparsable narrows them
+func handleListCallbacks(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := database.CallbackFilter{Limit: limitFromQuery(r, 100)}
+		if results := r.URL.Query().Get("result"); results != "" {
+			filter.Results = strings.Split(results, ",")
+		}
+
+		callbacks, err := db.ListCallbacks(filter)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list callbacks: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list callbacks")
+			return
+		}
+		writeJSON(w, http.StatusOK, callbacks)
+	}
+}
+
+// handleGetCallback returns one stored callback with its raw body
+func handleGetCallback(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "callback not found")
+			return
+		}
+
+		stored, err := db.FindCallback(id)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "callback not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to find callback %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to find callback")
+			return
+		}
+		writeJSON(w, http.StatusOK, stored)
+	}
+}
+
+// handlePaymentCallbacks lists the callbacks received for a payment
+func handlePaymentCallbacks(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		callbacks, err := db.ListCallbacks(database.CallbackFilter{PaymentID: id, Limit: limitFromQuery(r, 100)})
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list callbacks of payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to list callbacks")
+			return
+		}
+		writeJSON(w, http.StatusOK, callbacks)
+	}
+}
+
+// handleReplayCallback processes one stored callback again and returns it with the new outcome
+func handleReplayCallback(db *database.Database, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "callback not found")
+			return
+		}
+
+		stored, _, err := callback.Replay(r.Context(), db, cfg, id)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "callback not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to replay callback %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to replay callback")
+			return
+		}
+		writeJSON(w, http.StatusOK, stored)
+	}
+}
+
+// handleReplayFailedCallbacks replays every stored callback whose processing failed, up to ?limit=
+func handleReplayFailedCallbacks(db *database.Database, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		replayed, err := callback.ReplayFailed(r.Context(), db, cfg, limitFromQuery(r, 100))
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to replay callbacks: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to replay callbacks")
+			return
+		}
+		writeJSON(w, http.StatusOK, replayed)
+	}
+}
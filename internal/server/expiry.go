@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/expiry"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/transition"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type reopenRequest struct {
+	By     string `json:"by"`
+	Window string `json:"window"` // duration, e.g. "2h", the aggregator's expiry window if empty
+}
+
+// handleReopenPayment moves an expired deposit back to pending and applies
+// the callbacks that arrived after it expired
+func handleReopenPayment(db *database.Database, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		var body reopenRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid JSON body")
+				return
+			}
+		}
+		var window time.Duration
+		if body.Window != "" {
+			if window, err = time.ParseDuration(body.Window); err != nil || window <= 0 {
+				writeError(w, http.StatusBadRequest, "window must be a positive duration")
+				return
+			}
+		}
+		if body.By == "" {
+			body.By = "admin"
+		}
+
+		paymentDoc, replayed, err := expiry.Reopen(r.Context(), db, cfg, id, body.By, window)
+		var rejection *payment.Rejection
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		case errors.Is(err, transition.ErrNotAllowed), errors.Is(err, database.ErrConflict):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case errors.As(err, &rejection):
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": rejection.Reason, "rule": rejection.Rule})
+			return
+		case err != nil:
+			logger.ErrorLogger.Printf("Failed to re-open payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to re-open payment")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"payment": paymentDoc, "replayed_callbacks": replayed})
+	}
+}
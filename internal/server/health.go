@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"payment-aggregator/internal/circuitbreaker"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+)
+
+// how long /readyz waits for Mongo
+const pingTimeout = 2 * time.Second
+
+// handleHealthz only tells that the process is serving requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports ready once Mongo answers, the config is loaded
+// and at least one aggregator instance would take a call
+func handleReadyz(db *database.Database, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{"mongo": "ok", "config": "ok", "aggregators": "ok"}
+		ready := true
+
+		ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+		defer cancel()
+		if err := db.Ping(ctx); err != nil {
+			checks["mongo"] = err.Error()
+			ready = false
+		}
+
+		if cfg == nil || len(cfg.Instances) == 0 {
+			checks["config"] = "no aggregator instances configured"
+			ready = false
+		} else if !anyAggregatorHealthy(aggregatorStatuses(cfg)) {
+			checks["aggregators"] = "every aggregator circuit is open"
+			ready = false
+		}
+
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+	}
+}
+
+// aggregatorStatus is what /status shows of one aggregator instance
+type aggregatorStatus struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Circuit       string     `json:"circuit"`
+	ErrorRate     float64    `json:"error_rate"` // over the breaker's window of recent calls
+	Successes     int64      `json:"successes"`
+	Failures      int64      `json:"failures"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+}
+
+// handleStatus lists each aggregator instance's last successful call, error rate and circuit state
+func handleStatus(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"aggregators": aggregatorStatuses(cfg)})
+	}
+}
+
+func aggregatorStatuses(cfg *config.Config) []aggregatorStatus {
+	names := make([]string, 0, len(cfg.Instances))
+	for name := range cfg.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]aggregatorStatus, 0, len(names))
+	for _, name := range names {
+		snapshot := circuitbreaker.For(name).Snapshot()
+		statuses = append(statuses, aggregatorStatus{
+			Name:          name,
+			Type:          cfg.Instances[name].Type,
+			Circuit:       snapshot.State,
+			ErrorRate:     snapshot.FailureRate,
+			Successes:     snapshot.Successes,
+			Failures:      snapshot.Failures,
+			LastSuccessAt: timeOrNil(snapshot.LastSuccessAt),
+			LastFailureAt: timeOrNil(snapshot.LastFailureAt),
+		})
+	}
+	return statuses
+}
+
+func anyAggregatorHealthy(statuses []aggregatorStatus) bool {
+	for _, status := range statuses {
+		if status.Circuit != circuitbreaker.Open.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// timeOrNil keeps times that never happened out of the JSON
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/ledger"
+	"payment-aggregator/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// atFromQuery reads the optional ?at= point in time (RFC 3339), now by default
+func atFromQuery(r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("at")
+	if raw == "" {
+		return time.Now(), true
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	return at, err == nil
+}
+
+// handleAccountBalance returns a ledger account's balance at a point in time
+func handleAccountBalance(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		at, ok := atFromQuery(r)
+		if account == "" || !ok {
+			writeError(w, http.StatusBadRequest, "account is required and at must be RFC 3339")
+			return
+		}
+
+		balance, err := ledger.Balance(db, account, at)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to get balance of %s: %v", account, err)
+			writeError(w, http.StatusInternalServerError, "failed to get balance")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"account": account, "at": at, "balance": balance})
+	}
+}
+
+// handleMerchantBalance returns how much we owe a merchant at a point in time
+func handleMerchantBalance(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		at, ok := atFromQuery(r)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "at must be RFC 3339")
+			return
+		}
+
+		account := ledger.MerchantPayable(id)
+		balance, err := ledger.Balance(db, account, at)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to get balance of %s: %v", account, err)
+			writeError(w, http.StatusInternalServerError, "failed to get balance")
+			return
+		}
+		// payables are credit balances, show them as the amount owed
+		writeJSON(w, http.StatusOK, map[string]interface{}{"account": account, "at": at, "owed": -balance})
+	}
+}
+
+// handleLedgerCheck verifies that every journal sums to zero
+func handleLedgerCheck(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unbalanced, err := ledger.Check(db)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"balanced": false, "error": err.Error(), "journals": unbalanced})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"balanced": true})
+	}
+}
+
+// handlePaymentJournals lists the journals posted for a payment
+func handlePaymentJournals(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+		journals, err := db.ListJournals(id)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list journals of %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to list journals")
+			return
+		}
+		writeJSON(w, http.StatusOK, journals)
+	}
+}
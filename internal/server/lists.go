@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/screening"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type listEntryRequest struct {
+	List      string    `json:"list"` // block or allow
+	Kind      string    `json:"kind"` // iban, payer or bank
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at"` // RFC 3339, never expires when omitted
+}
+
+// handleListEntries lists the blocklist and allowlist, ?list=, ?kind= narrow them and
+// ?inactive=true includes expired and removed entries
+func handleListEntries(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		entries, err := db.ListEntries(database.ListEntryFilter{
+			List:     query.Get("list"),
+			Kind:     query.Get("kind"),
+			Inactive: query.Get("inactive") == "true",
+		})
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list blocklist entries: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list entries")
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// handleAddListEntry adds an IBAN, payer or bank to the blocklist or allowlist
+func handleAddListEntry(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body listEntryRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if body.CreatedBy == "" {
+			body.CreatedBy = "admin"
+		}
+
+		entry, err := screening.NewEntry(body.List, body.Kind, body.Value, body.Reason, body.CreatedBy, body.ExpiresAt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.InsertListEntry(&entry); err != nil {
+			logger.ErrorLogger.Printf("Failed to add blocklist entry: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to add entry")
+			return
+		}
+		logger.InfoLogger.Printf("Screening: %s added %s %s %q to the %slist: %s", entry.CreatedBy, entry.Kind, entry.ID.Hex(), entry.Value, entry.List, entry.Reason)
+		writeJSON(w, http.StatusCreated, entry)
+	}
+}
+
+// handleRemoveListEntry takes an entry off its list, ?by= names who removed it
+func handleRemoveListEntry(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "entry not found")
+			return
+		}
+		removedBy := r.URL.Query().Get("by")
+		if removedBy == "" {
+			removedBy = "admin"
+		}
+
+		entry, err := db.RemoveListEntry(id, removedBy)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "entry not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to remove blocklist entry %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to remove entry")
+			return
+		}
+		logger.InfoLogger.Printf("Screening: %s removed %s %s %q from the %slist", removedBy, entry.Kind, entry.ID.Hex(), entry.Value, entry.List)
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
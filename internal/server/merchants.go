@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type createMerchantRequest struct {
+	Name               string   `json:"name"`
+	CallbackURL        string   `json:"callback_url"`
+	AllowedAggregators []string `json:"allowed_aggregators"`
+}
+
+// issuedKey is the only response that ever contains a plain API key
+type issuedKey struct {
+	Merchant models.MerchantModel `json:"merchant"`
+	APIKey   string               `json:"api_key"`
+}
+
+func handleListMerchants(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchants, err := db.ListMerchants()
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list merchants: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list merchants")
+			return
+		}
+		writeJSON(w, http.StatusOK, merchants)
+	}
+}
+
+// handleCreateMerchant creates an active merchant with its first API key
+func handleCreateMerchant(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body createMerchantRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+			writeError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+
+		plain, key, err := auth.GenerateAPIKey()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate API key")
+			return
+		}
+
+		merchant := models.MerchantModel{
+			Name:               body.Name,
+			Status:             models.MerchantActive,
+			APIKeys:            []models.APIKeyModel{key},
+			CallbackURL:        body.CallbackURL,
+			AllowedAggregators: body.AllowedAggregators,
+		}
+		if err := db.InsertMerchant(&merchant); err != nil {
+			logger.ErrorLogger.Printf("Failed to insert merchant: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to create merchant")
+			return
+		}
+
+		logger.InfoLogger.Printf("Merchant %s (%s) created", merchant.Name, merchant.ID.Hex())
+		writeJSON(w, http.StatusCreated, issuedKey{Merchant: merchant, APIKey: plain})
+	}
+}
+
+// handleIssueAPIKey adds another API key to a merchant, e.g. for rotation
+func handleIssueAPIKey(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		plain, key, err := auth.GenerateAPIKey()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate API key")
+			return
+		}
+
+		err = db.AddMerchantAPIKey(id, key)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to add API key to merchant %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to issue API key")
+			return
+		}
+
+		merchant, err := db.FindMerchant(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load merchant")
+			return
+		}
+		writeJSON(w, http.StatusCreated, issuedKey{Merchant: merchant, APIKey: plain})
+	}
+}
+
+// handleRevokeAPIKey revokes one of a merchant's API keys, named by its prefix.
+// Requests with it are refused from then on, the merchant's other keys keep working
+func handleRevokeAPIKey(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		merchant, err := db.RevokeMerchantAPIKey(id, r.PathValue("prefix"))
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "active API key not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to revoke API key %s of merchant %s: %v", r.PathValue("prefix"), id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to revoke API key")
+			return
+		}
+		logger.InfoLogger.Printf("API key %s of merchant %s revoked", r.PathValue("prefix"), id.Hex())
+		writeJSON(w, http.StatusOK, merchant)
+	}
+}
+
+// handleSetMerchantStatus activates or suspends a merchant
+func handleSetMerchantStatus(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil ||
+			(body.Status != models.MerchantActive && body.Status != models.MerchantSuspended) {
+			writeError(w, http.StatusBadRequest, "status must be active or suspended")
+			return
+		}
+
+		err = db.SetMerchantStatus(id, body.Status)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to update merchant %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to update merchant")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleSetMerchantLimits replaces a merchant's per-endpoint rate limits and concurrency quota
+func handleSetMerchantLimits(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		var body struct {
+			RateLimits    map[string]models.RateLimitModel `json:"rate_limits"`
+			MaxConcurrent int                              `json:"max_concurrent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MaxConcurrent < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limits")
+			return
+		}
+		for endpoint, limit := range body.RateLimits {
+			if limit.RequestsPerSecond <= 0 || limit.Burst < 1 {
+				writeError(w, http.StatusBadRequest, "invalid limit for "+endpoint)
+				return
+			}
+		}
+
+		err = db.SetMerchantLimits(id, body.RateLimits, body.MaxConcurrent)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to update limits of merchant %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to update limits")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleSetMerchantFees replaces a merchant's fee schedules, keyed by aggregator instance or "default"
+func handleSetMerchantFees(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		var body map[string]models.FeeScheduleModel
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid fee schedules")
+			return
+		}
+		for name, schedule := range body {
+			if schedule.Fixed < 0 || schedule.Percentage < 0 || schedule.Percentage > 100 || (schedule.Max > 0 && schedule.Max < schedule.Min) {
+				writeError(w, http.StatusBadRequest, "invalid fee schedule for "+name)
+				return
+			}
+		}
+
+		err = db.SetMerchantFees(id, body)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to update fees of merchant %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to update fees")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleSetMerchantTransactionLimits replaces a merchant's amount and count limits, keyed by transaction type
+func handleSetMerchantTransactionLimits(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+
+		var body map[string]models.TransactionLimitsModel
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid transaction limits")
+			return
+		}
+		for transactionType, limits := range body {
+			if transactionType != models.TypeDeposit && transactionType != models.TypeWithdrawal {
+				writeError(w, http.StatusBadRequest, "unknown transaction type "+transactionType)
+				return
+			}
+			if limits.MinAmount < 0 || limits.MaxAmount < 0 || limits.DailyAmount < 0 || limits.MonthlyAmount < 0 ||
+				limits.DailyCount < 0 || limits.MonthlyCount < 0 || (limits.MaxAmount > 0 && limits.MaxAmount < limits.MinAmount) {
+				writeError(w, http.StatusBadRequest, "invalid transaction limits for "+transactionType)
+				return
+			}
+		}
+
+		err = db.SetMerchantTransactionLimits(id, body)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "merchant not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to update transaction limits of merchant %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to update transaction limits")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
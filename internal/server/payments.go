@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/deposit"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type depositRequest struct {
+	Amount    float64 `json:"amount"`
+	BankID    string  `json:"bank_id,omitempty"`    // provider account to deposit to, the first one if empty
+	PayerName string  `json:"payer_name,omitempty"` // who will send the money, checked against the account holder
+	PayerIBAN string  `json:"payer_iban,omitempty"` // the IBAN they send it from, counted towards IBAN limits
+}
+
+type depositResult struct {
+	Deposit payment.DepositResponse `json:"deposit"`
+	Payment models.PaymentModel     `json:"payment"`
+}
+
+// handleCreateDeposit runs a deposit for the authenticated merchant
+func handleCreateDeposit(deposits *deposit.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, _ := auth.MerchantFromContext(r.Context())
+
+		var body depositRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if body.Amount <= 0 {
+			writeError(w, http.StatusBadRequest, "amount must be positive")
+			return
+		}
+
+		selectAccount := payment.SelectFirst
+		if body.BankID != "" {
+			selectAccount = payment.SelectByID(body.BankID)
+		}
+
+		resp, paymentDoc, err := deposits.Deposit(r.Context(), merchant, body.Amount, deposit.Payer{Name: body.PayerName, IBAN: body.PayerIBAN}, selectAccount)
+		if errors.Is(err, shutdown.ErrShuttingDown) {
+			writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+			return
+		}
+		var rejection *payment.Rejection
+		if errors.As(err, &rejection) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"error":          rejection.Reason,
+				"rule":           rejection.Rule,
+				"attempts":       paymentDoc.Attempts,
+				"correlation_id": paymentDoc.CorrelationID,
+			})
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Deposit for merchant %s failed (correlation %s): %v", merchant.ID.Hex(), paymentDoc.CorrelationID, err)
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+				"error":          err.Error(),
+				"attempts":       paymentDoc.Attempts,
+				"correlation_id": paymentDoc.CorrelationID,
+			})
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, depositResult{Deposit: resp, Payment: paymentDoc})
+	}
+}
+
+// handlePreviewDeposit returns the fees a deposit would be charged, without making it
+func handlePreviewDeposit(deposits *deposit.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, _ := auth.MerchantFromContext(r.Context())
+
+		var body depositRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Amount <= 0 {
+			writeError(w, http.StatusBadRequest, "amount must be positive")
+			return
+		}
+
+		previews, err := deposits.Preview(merchant, body.Amount)
+		if err != nil {
+			logger.ErrorLogger.Printf("Fee preview for merchant %s failed: %v", merchant.ID.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to calculate fees")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"amount": body.Amount, "fees": previews})
+	}
+}
+
+// handleListPayments lists the authenticated merchant's payments
+func handleListPayments(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, _ := auth.MerchantFromContext(r.Context())
+
+		limit := int64(100)
+		if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 && v <= 1000 {
+			limit = v
+		}
+
+		payments, err := db.ListPayments(merchant.ID, limit)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list payments: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list payments")
+			return
+		}
+		writeJSON(w, http.StatusOK, payments)
+	}
+}
+
+// handleGetPayment returns one of the authenticated merchant's payments
+func handleGetPayment(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, _ := auth.MerchantFromContext(r.Context())
+
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		paymentDoc, err := db.FindPayment(merchant.ID, id)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to find payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to find payment")
+			return
+		}
+		writeJSON(w, http.StatusOK, paymentDoc)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/refund"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type refundRequest struct {
+	Amount float64 `json:"amount"` // 0 or missing refunds the whole refundable balance
+	Reason string  `json:"reason"`
+}
+
+// handleCreateRefund refunds one of the authenticated merchant's confirmed deposits
+func handleCreateRefund(refunds *refund.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, _ := auth.MerchantFromContext(r.Context())
+
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		var body refundRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Amount < 0 {
+			writeError(w, http.StatusBadRequest, "invalid refund request")
+			return
+		}
+
+		refundDoc, err := refunds.Refund(merchant.ID, id, body.Amount, body.Reason)
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			writeError(w, http.StatusNotFound, "payment not found")
+		case errors.Is(err, database.ErrNotRefundable), errors.Is(err, refund.ErrInvalidAmount):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		case err != nil:
+			logger.ErrorLogger.Printf("Refund of payment %s failed: %v", id.Hex(), err)
+			writeError(w, http.StatusBadGateway, err.Error())
+		default:
+			writeJSON(w, http.StatusCreated, refundDoc)
+		}
+	}
+}
+
+// handleCompleteRefund records the outcome of a manual payout or pending refund
+func handleCompleteRefund(refunds *refund.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "refund not found")
+			return
+		}
+
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil ||
+			(body.Status != models.StatusConfirmed && body.Status != models.StatusFailed) {
+			writeError(w, http.StatusBadRequest, "status must be confirmed or failed")
+			return
+		}
+
+		refundDoc, err := refunds.Complete(id, body.Status)
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			writeError(w, http.StatusNotFound, "refund not found")
+		case errors.Is(err, refund.ErrNotRefund), errors.Is(err, database.ErrConflict):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			logger.ErrorLogger.Printf("Failed to complete refund %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to complete refund")
+		default:
+			writeJSON(w, http.StatusOK, refundDoc)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/report"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handleSettlementReport exports the settlement report as CSV or XLSX.
+// Merchants only get their own payments, admins can pick one with ?merchant=
+func handleSettlementReport(db *database.Database, admin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		today := time.Now().Format("2006-01-02")
+		if loc, err := time.LoadLocation(report.Timezone); err == nil {
+			today = time.Now().In(loc).Format("2006-01-02")
+		}
+		fromDay, toDay := query.Get("from"), query.Get("to")
+		if fromDay == "" {
+			fromDay = today
+		}
+		if toDay == "" {
+			toDay = fromDay
+		}
+		from, errFrom := report.ParseDay(fromDay)
+		to, errTo := report.ParseDay(toDay)
+		if errFrom != nil || errTo != nil {
+			writeError(w, http.StatusBadRequest, "from and to must be YYYY-MM-DD")
+			return
+		}
+
+		format := query.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "xlsx" {
+			writeError(w, http.StatusBadRequest, "format must be csv or xlsx")
+			return
+		}
+
+		var merchantID primitive.ObjectID
+		if admin {
+			if raw := query.Get("merchant"); raw != "" {
+				id, err := primitive.ObjectIDFromHex(raw)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "invalid merchant")
+					return
+				}
+				merchantID = id
+			}
+		} else {
+			merchant, _ := auth.MerchantFromContext(r.Context())
+			merchantID = merchant.ID
+		}
+
+		settlement, err := report.Generate(db, from, to, merchantID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to generate settlement report: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to generate report")
+			return
+		}
+
+		w.Header().Set("Content-Type", report.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=settlement_%s_%s.%s", settlement.From, settlement.To, format))
+		if err := settlement.Write(w, format); err != nil {
+			logger.ErrorLogger.Printf("Failed to write settlement report: %v", err)
+		}
+	}
+}
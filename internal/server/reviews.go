@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/refund"
+	"payment-aggregator/internal/risk"
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type reviewRequest struct {
+	Outcome  string `json:"outcome"` // approved or rejected
+	Reviewer string `json:"reviewer"`
+	Note     string `json:"note"`
+}
+
+// handleListReviews lists the payments waiting in the risk review queue, oldest first
+func handleListReviews(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payments, err := db.ListPaymentsForReview(limitFromQuery(r, 100))
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to list the review queue: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list the review queue")
+			return
+		}
+		writeJSON(w, http.StatusOK, payments)
+	}
+}
+
+// handleReview records an operator's decision on a payment in the review queue
+func handleReview(db *database.Database, refunds *refund.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+
+		var body reviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if body.Outcome != models.ReviewApproved && body.Outcome != models.ReviewRejected {
+			writeError(w, http.StatusBadRequest, "outcome must be approved or rejected")
+			return
+		}
+		if body.Reviewer == "" {
+			body.Reviewer = "admin"
+		}
+
+		paymentDoc, err := risk.Review(db, refunds, id, body.Outcome, body.Reviewer, body.Note)
+		if errors.Is(err, database.ErrNotInReview) {
+			writeError(w, http.StatusConflict, "payment is not waiting for review")
+			return
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to review payment %s: %v", id.Hex(), err)
+			writeError(w, http.StatusInternalServerError, "failed to review payment")
+			return
+		}
+		writeJSON(w, http.StatusOK, paymentDoc)
+	}
+}
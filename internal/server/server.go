@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"payment-aggregator/internal/auth"
+	"payment-aggregator/internal/callback"
+	"payment-aggregator/internal/circuitbreaker"
+	"payment-aggregator/internal/config"
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/deposit"
+	"payment-aggregator/internal/metrics"
+	"payment-aggregator/internal/ratelimit"
+	"payment-aggregator/internal/refund"
+	"payment-aggregator/internal/shutdown"
+	"payment-aggregator/internal/tracing"
+	"payment-aggregator/models"
+	"time"
+)
+
+// StartServer starts the HTTP server with the callback, merchant and admin routes.
+// On shutdown it stops accepting connections and waits for in-flight requests
+func StartServer(db *database.Database, cfg *config.Config, deposits *deposit.Service, lifecycle *shutdown.Manager) error {
+	listener, err := net.Listen("tcp", cfg.ServerAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", cfg.ServerAddr, err)
+	}
+
+	srv := &http.Server{
+		Handler:           tracing.Handler(Routes(db, cfg, deposits)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	lifecycle.OnShutdown("http server", shutdown.OrderServer, srv.Shutdown)
+
+	// Log the server start and errors
+	log.Println("Server is starting on", cfg.ServerAddr)
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			lifecycle.Fail(fmt.Errorf("http server: %w", err))
+		}
+	}()
+
+	return startMetricsServer(cfg, lifecycle)
+}
+
+// startMetricsServer serves /metrics on its own internal listener, so the scraper
+// needs neither the admin key nor access to the public one
+func startMetricsServer(cfg *config.Config, lifecycle *shutdown.Manager) error {
+	if cfg.MetricsAddr == "off" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", cfg.MetricsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s for metrics: %w", cfg.MetricsAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", auth.RequireScrapeToken(metrics.Handler()))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	lifecycle.OnShutdown("metrics server", shutdown.OrderServer, srv.Shutdown)
+
+	log.Println("Metrics are served on", cfg.MetricsAddr)
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			lifecycle.Fail(fmt.Errorf("metrics server: %w", err))
+		}
+	}()
+	return nil
+}
+
+// Routes builds the HTTP handler. Every route except /callback and the probes needs an API key
+func Routes(db *database.Database, cfg *config.Config, deposits *deposit.Service) http.Handler {
+	mux := http.NewServeMux()
+	refunds := refund.NewService(db, cfg)
+
+	// Handle /callback route, called by the aggregators.
+	// /callback/{instance} tells which aggregator instance is calling
+	callback := func(w http.ResponseWriter, r *http.Request) {
+		HandleCallback(w, r, db, cfg)
+	}
+	mux.HandleFunc("/callback", callback)
+	mux.HandleFunc("/callback/{instance}", callback)
+
+	// Probes for the orchestrator, no API key needed
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.Handle("GET /readyz", handleReadyz(db, cfg))
+	mux.Handle("GET /status", handleStatus(cfg))
+
+	// Merchant API, rate limited per merchant and endpoint
+	limiter := ratelimit.FromEnv(db)
+	concurrency := ratelimit.ConcurrencyFromEnv(db)
+	merchant := func(endpoint string, h http.Handler) http.Handler {
+		return auth.RequireMerchant(db, ratelimit.Limit(limiter, endpoint, h))
+	}
+	mux.Handle("POST /deposits", merchant("deposits", ratelimit.LimitConcurrency(concurrency, handleCreateDeposit(deposits))))
+	mux.Handle("POST /deposits/preview", merchant("payments", handlePreviewDeposit(deposits)))
+	mux.Handle("GET /payments", merchant("payments", handleListPayments(db)))
+	mux.Handle("GET /payments/{id}", merchant("payments", handleGetPayment(db)))
+	mux.Handle("POST /payments/{id}/refunds", merchant("refunds", handleCreateRefund(refunds)))
+	mux.Handle("GET /reports/settlement", merchant("reports", handleSettlementReport(db, false)))
+
+	// Admin API
+	mux.Handle("GET /admin/circuits", auth.RequireAdmin(http.HandlerFunc(circuitbreaker.HandleStatus)))
+	mux.Handle("GET /admin/merchants", auth.RequireAdmin(handleListMerchants(db)))
+	mux.Handle("POST /admin/merchants", auth.RequireAdmin(handleCreateMerchant(db)))
+	mux.Handle("POST /admin/merchants/{id}/keys", auth.RequireAdmin(handleIssueAPIKey(db)))
+	mux.Handle("POST /admin/merchants/{id}/keys/{prefix}/revoke", auth.RequireAdmin(handleRevokeAPIKey(db)))
+	mux.Handle("POST /admin/merchants/{id}/status", auth.RequireAdmin(handleSetMerchantStatus(db)))
+	mux.Handle("POST /admin/merchants/{id}/limits", auth.RequireAdmin(handleSetMerchantLimits(db)))
+	mux.Handle("POST /admin/merchants/{id}/fees", auth.RequireAdmin(handleSetMerchantFees(db)))
+	mux.Handle("POST /admin/merchants/{id}/transaction-limits", auth.RequireAdmin(handleSetMerchantTransactionLimits(db)))
+	mux.Handle("GET /admin/merchants/{id}/balance", auth.RequireAdmin(handleMerchantBalance(db)))
+	mux.Handle("GET /admin/payments/{id}/journals", auth.RequireAdmin(handlePaymentJournals(db)))
+	mux.Handle("GET /admin/reports/settlement", auth.RequireAdmin(handleSettlementReport(db, true)))
+	mux.Handle("GET /admin/ledger/balance", auth.RequireAdmin(handleAccountBalance(db)))
+	mux.Handle("GET /admin/ledger/check", auth.RequireAdmin(handleLedgerCheck(db)))
+	mux.Handle("POST /admin/refunds/{id}/status", auth.RequireAdmin(handleCompleteRefund(refunds)))
+	mux.Handle("GET /admin/callbacks", auth.RequireAdmin(handleListCallbacks(db)))
+	mux.Handle("GET /admin/callbacks/{id}", auth.RequireAdmin(handleGetCallback(db)))
+	mux.Handle("POST /admin/callbacks/{id}/replay", auth.RequireAdmin(handleReplayCallback(db, cfg)))
+	mux.Handle("POST /admin/callbacks/replay-failed", auth.RequireAdmin(handleReplayFailedCallbacks(db, cfg)))
+	mux.Handle("GET /admin/payments/{id}/audit", auth.RequireAdmin(handlePaymentAudit(db)))
+	mux.Handle("GET /admin/audit", auth.RequireAdmin(handleCorrelationAudit(db)))
+	mux.Handle("POST /admin/payments/{id}/reopen", auth.RequireAdmin(handleReopenPayment(db, cfg)))
+	mux.Handle("GET /admin/reviews", auth.RequireAdmin(handleListReviews(db)))
+	mux.Handle("POST /admin/reviews/{id}", auth.RequireAdmin(handleReview(db, refunds)))
+	mux.Handle("GET /admin/lists", auth.RequireAdmin(handleListEntries(db)))
+	mux.Handle("POST /admin/lists", auth.RequireAdmin(handleAddListEntry(db)))
+	mux.Handle("DELETE /admin/lists/{id}", auth.RequireAdmin(handleRemoveListEntry(db)))
+	mux.Handle("GET /admin/payments/{id}/callbacks", auth.RequireAdmin(handlePaymentCallbacks(db)))
+	mux.Handle("GET /debug/vars", auth.RequireAdmin(expvar.Handler()))
+
+	return mux
+}
+
+func HandleCallback(w http.ResponseWriter, r *http.Request, db *database.Database, cfg *config.Config) {
+
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		log.Println("Ignored non-POST request:", r.Method)
+		metrics.Callbacks.WithLabelValues("rejected").Inc()
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Read the body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println("Error reading body:", err)
+		metrics.Callbacks.WithLabelValues("rejected").Inc()
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+
+	// Store it as received, then let the aggregator's adapter verify and read it and apply the status
+	sourceIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	_, outcome := callback.Receive(r.Context(), db, cfg, models.CallbackModel{
+		Aggregator: r.PathValue("instance"),
+		Method:     r.Method,
+		Headers:    r.Header,
+		Body:       string(body),
+		SourceIP:   sourceIP,
+	})
+	switch outcome.Result {
+	case callback.ResultRejected:
+		http.Error(w, "callback verification failed", http.StatusUnauthorized)
+		return
+	case callback.ResultUnverified:
+		http.Error(w, "callback cannot be verified", http.StatusUnauthorized)
+		return
+	case callback.ResultUnparsable:
+		http.Error(w, "cannot parse callback", http.StatusBadRequest)
+		return
+	case callback.ResultUnknownTransaction:
+		http.Error(w, "unknown transaction", http.StatusNotFound)
+		return
+	case callback.ResultError:
+		http.Error(w, "cannot process callback", http.StatusInternalServerError)
+		return
+	}
+	// ignored callbacks are acknowledged anyway, retrying won't make the transition valid
+
+	// Respond OK
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Callback received"))
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error as {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
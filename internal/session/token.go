@@ -0,0 +1,134 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"payment-aggregator/internal/logger"
+)
+
+// ErrUnauthorized should be wrapped by adapters when the provider rejects a token,
+// so Do can retry the call once with a fresh one
+var ErrUnauthorized = errors.New("unauthorized")
+
+// fetchTimeout bounds a token fetch, which no single caller can cancel
+const fetchTimeout = 30 * time.Second
+
+// Fetcher requests a new session token from a provider and reports when it expires
+type Fetcher func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+// Manager caches one aggregator's session token, refreshes it before it expires
+// and is safe for concurrent use by many flows
+type Manager struct {
+	name          string
+	fetch         Fetcher
+	refreshBefore time.Duration
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	inflight  *fetchCall // shared by every caller waiting on the same fetch
+}
+
+type fetchCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewManager creates a manager that refreshes tokens refreshBefore their expiry
+func NewManager(name string, fetch Fetcher, refreshBefore time.Duration) *Manager {
+	return &Manager{name: name, fetch: fetch, refreshBefore: refreshBefore}
+}
+
+// Token returns the cached token, fetching a new one if there is none or it has expired.
+// A token that is about to expire is still returned while a refresh runs in the background.
+// The fetch is shared by every waiting caller, so it carries ctx's trace but not its cancellation
+func (m *Manager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+
+	if m.token != "" && now.Before(m.expiresAt) {
+		token := m.token
+		if now.After(m.expiresAt.Add(-m.refreshBefore)) && m.inflight == nil {
+			logger.InfoLogger.Printf("Token %s: expiring at %s, refreshing in background", m.name, m.expiresAt.Format(time.RFC3339))
+			m.startFetchLocked(ctx)
+		}
+		m.mu.Unlock()
+		return token, nil
+	}
+
+	call := m.inflight
+	if call == nil {
+		call = m.startFetchLocked(ctx)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token if it is still the given one,
+// so a token rejected by the provider is not handed out again
+func (m *Manager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == token {
+		m.token = ""
+		m.expiresAt = time.Time{}
+	}
+}
+
+// Do calls fn with a valid token and retries it once with a fresh token
+// if fn fails with ErrUnauthorized
+func (m *Manager) Do(ctx context.Context, fn func(token string) error) error {
+	token, err := m.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(token)
+	if !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	logger.WarningLogger.Printf("Token %s: rejected by provider, retrying with a fresh token", m.name)
+	m.Invalidate(token)
+	token, err = m.Token(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(token)
+}
+
+func (m *Manager) startFetchLocked(ctx context.Context) *fetchCall {
+	call := &fetchCall{done: make(chan struct{})}
+	m.inflight = call
+
+	go func() {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fetchTimeout)
+		token, expiresAt, err := m.fetch(fetchCtx)
+		cancel()
+
+		m.mu.Lock()
+		if err == nil {
+			m.token = token
+			m.expiresAt = expiresAt
+		} else {
+			logger.ErrorLogger.Printf("Token %s: refresh failed: %v", m.name, err)
+		}
+		m.inflight = nil
+		m.mu.Unlock()
+
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+
+	return call
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payment-aggregator/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Discard()
+	os.Exit(m.Run())
+}
+
+// tokenEndpoint is a fake provider login that hands out token-1, token-2, ... and counts the logins
+type tokenEndpoint struct {
+	server *httptest.Server
+	calls  atomic.Int32
+}
+
+func newTokenEndpoint(t *testing.T, delay time.Duration) *tokenEndpoint {
+	e := &tokenEndpoint{}
+	e.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := e.calls.Add(1)
+		time.Sleep(delay)
+		fmt.Fprintf(w, "token-%d", n)
+	}))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+func (e *tokenEndpoint) fetch(ctx context.Context) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.server.URL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	var token string
+	if _, err := fmt.Fscan(resp.Body, &token); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, time.Now().Add(time.Hour), nil
+}
+
+func TestTokenSharesRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		callers int
+	}{
+		{"one caller", 1},
+		{"concurrent callers", 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newTokenEndpoint(t, 50*time.Millisecond)
+			m := NewManager("test", endpoint.fetch, time.Minute)
+
+			var wg sync.WaitGroup
+			tokens := make([]string, tt.callers)
+			errs := make([]error, tt.callers)
+			for i := 0; i < tt.callers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					tokens[i], errs[i] = m.Token(context.Background())
+				}(i)
+			}
+			wg.Wait()
+
+			if got := endpoint.calls.Load(); got != 1 {
+				t.Fatalf("%d token requests, want 1", got)
+			}
+			for i := range tokens {
+				if errs[i] != nil || tokens[i] != "token-1" {
+					t.Fatalf("caller %d got %q, %v, want token-1", i, tokens[i], errs[i])
+				}
+			}
+
+			// the cached token is handed out without another request
+			if token, err := m.Token(context.Background()); err != nil || token != "token-1" {
+				t.Fatalf("cached token %q, %v, want token-1", token, err)
+			}
+			if got := endpoint.calls.Load(); got != 1 {
+				t.Fatalf("%d token requests after a cached call, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDoRetriesUnauthorized(t *testing.T) {
+	errProvider := errors.New("provider down")
+
+	tests := []struct {
+		name         string
+		results      []error // fn's result on each call
+		wantErr      error
+		wantTokens   []string // the token fn got on each call
+		wantRequests int32
+	}{
+		{"accepted", []error{nil}, nil, []string{"token-1"}, 1},
+		{"rejected then accepted", []error{ErrUnauthorized, nil}, nil, []string{"token-1", "token-2"}, 2},
+		{"rejected twice", []error{ErrUnauthorized, ErrUnauthorized}, ErrUnauthorized, []string{"token-1", "token-2"}, 2},
+		{"other error", []error{errProvider}, errProvider, []string{"token-1"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newTokenEndpoint(t, 0)
+			m := NewManager("test", endpoint.fetch, time.Minute)
+
+			var got []string
+			err := m.Do(context.Background(), func(token string) error {
+				got = append(got, token)
+				if len(got) > len(tt.results) {
+					t.Fatalf("fn called %d times, want %d", len(got), len(tt.results))
+				}
+				if res := tt.results[len(got)-1]; res != nil {
+					return fmt.Errorf("request: %w", res)
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantTokens) {
+				t.Fatalf("fn got tokens %v, want %v", got, tt.wantTokens)
+			}
+			if n := endpoint.calls.Load(); n != tt.wantRequests {
+				t.Fatalf("%d token requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrShuttingDown is returned when work is refused because shutdown has started
+var ErrShuttingDown = errors.New("shutting down")
+
+// Group tracks in-flight work so shutdown can wait for it.
+// The zero value is ready to use
+type Group struct {
+	mu      sync.Mutex
+	closing bool
+	active  int
+	idle    chan struct{} // closed once closing and nothing is active
+}
+
+// Enter registers one unit of work, the returned func marks it done.
+// Once Drain has been called no new work is accepted
+func (g *Group) Enter() (done func(), err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing {
+		return nil, ErrShuttingDown
+	}
+	g.active++
+
+	var once sync.Once
+	return func() { once.Do(g.leave) }, nil
+}
+
+// Go runs fn in a goroutine tracked by the group
+func (g *Group) Go(fn func()) error {
+	done, err := g.Enter()
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer done()
+		fn()
+	}()
+	return nil
+}
+
+func (g *Group) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.closing && g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Drain stops accepting work and waits for what is in flight, or until ctx is done
+func (g *Group) Drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.closing = true
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	active := g.active
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d still in flight: %w", active, ctx.Err())
+	}
+}
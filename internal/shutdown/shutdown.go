@@ -1,30 +1,127 @@
 package shutdown
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/signal"
-	"payment-aggregator/internal/database"
 	"payment-aggregator/internal/logger"
+	"sort"
+	"sync"
 	"syscall"
+	"time"
 )
 
-// WaitForShutdown listens for OS signals and handles graceful shutdown.
-func WaitForShutdown(db *database.Database) {
-	// Create a channel to listen for OS signals (e.g., SIGINT, SIGTERM)
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+// order in which hooks run, lower first. Components registering
+// in the same phase run in registration order
+const (
+	OrderServer    = 10  // stop accepting requests, wait for in-flight handlers
+	OrderFlows     = 20  // wait for deposit flows started outside the server
+	OrderWorkers   = 30  // background workers and outbound deliveries
+	OrderDatabase  = 90  // nothing may use Mongo after this
+	OrderTelemetry = 100 // flush spans last so the shutdown itself is traced
+)
+
+// DefaultTimeout bounds the whole shutdown when SHUTDOWN_TIMEOUT is not set
+const DefaultTimeout = 30 * time.Second
+
+// Hook is a cleanup step run during shutdown
+type Hook func(ctx context.Context) error
+
+type namedHook struct {
+	name  string
+	order int
+	fn    Hook
+}
+
+// Manager runs the registered hooks in order once a signal arrives
+type Manager struct {
+	timeout time.Duration
+	signals chan os.Signal
+	failed  chan error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	hooks []namedHook
+	once  sync.Once
+	err   error
+}
+
+// NewManager starts listening for SIGINT and SIGTERM right away,
+// so a signal during startup isn't lost
+func NewManager(timeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{timeout: timeout, signals: make(chan os.Signal, 1), failed: make(chan error, 1), ctx: ctx, cancel: cancel}
+	signal.Notify(m.signals, syscall.SIGINT, syscall.SIGTERM)
+	return m
+}
+
+// TimeoutFromEnv reads SHUTDOWN_TIMEOUT, DefaultTimeout otherwise
+func TimeoutFromEnv() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultTimeout
+}
+
+// Context is cancelled as soon as shutdown starts, background loops should stop on it
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
 
-	// Block until we receive a signal
-	<-signalChannel
+// OnShutdown registers a hook to run at the given order
+func (m *Manager) OnShutdown(name string, order int, fn Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, namedHook{name: name, order: order, fn: fn})
+}
 
-	// Handle graceful shutdown
-	logger.InfoLogger.Println("Shutdown signal received, closing connections and cleaning up...")
+// Fail makes Wait shut down without a signal, for components that can't go on
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
 
-	// Perform cleanup actions, such as closing the database connection
-	err := db.Close()
-	if err != nil {
-		logger.ErrorLogger.Printf("Error during shutdown: %v", err)
-	} else {
-		logger.InfoLogger.Println("Database connection closed successfully.")
+// Wait blocks until a signal arrives or a component fails, then shuts down
+func (m *Manager) Wait() error {
+	select {
+	case sig := <-m.signals:
+		logger.InfoLogger.Printf("Shutdown signal %s received, closing connections and cleaning up...", sig)
+	case err := <-m.failed:
+		logger.ErrorLogger.Printf("Shutting down after failure: %v", err)
 	}
+	return m.Shutdown()
+}
+
+// Shutdown cancels Context and runs every hook in order, all sharing one deadline.
+// A failing hook doesn't stop the ones after it. Only the first call does anything
+func (m *Manager) Shutdown() error {
+	m.once.Do(func() {
+		m.cancel()
+
+		m.mu.Lock()
+		hooks := append([]namedHook(nil), m.hooks...)
+		m.mu.Unlock()
+		sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].order < hooks[j].order })
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+
+		var errs []error
+		for _, hook := range hooks {
+			start := time.Now()
+			if err := hook.fn(ctx); err != nil {
+				logger.ErrorLogger.Printf("Shutdown: %s failed after %s: %v", hook.name, time.Since(start), err)
+				errs = append(errs, err)
+				continue
+			}
+			logger.InfoLogger.Printf("Shutdown: %s done in %s", hook.name, time.Since(start))
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
 }
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "payment-aggregator"
+
+// Settings choose where spans are exported
+type Settings struct {
+	Exporter    string // "otlp", "stdout" or "none"
+	ServiceName string
+	SampleRatio float64 // share of new traces recorded, 1 records all
+}
+
+// SettingsFromEnv reads TRACING_EXPORTER, TRACING_SERVICE_NAME and TRACING_SAMPLE_RATIO.
+// The OTLP exporter itself is configured with the standard OTEL_EXPORTER_OTLP_* variables
+func SettingsFromEnv() Settings {
+	settings := Settings{Exporter: "none", ServiceName: "payment-aggregator", SampleRatio: 1}
+	if v := os.Getenv("TRACING_EXPORTER"); v != "" {
+		settings.Exporter = v
+	}
+	if v := os.Getenv("TRACING_SERVICE_NAME"); v != "" {
+		settings.ServiceName = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("TRACING_SAMPLE_RATIO"), 64); err == nil {
+		settings.SampleRatio = v
+	}
+	return settings
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// The returned function flushes and stops the exporter
+func Setup(settings Settings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch settings.Exporter {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", settings.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", settings.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(settings.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the one in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport wraps base so outbound requests get a client span and carry the trace context
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// Handler wraps the HTTP server so every request gets a server span,
+// continuing the caller's trace when it sends one
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http.server", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))
+}
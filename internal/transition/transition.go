@@ -0,0 +1,130 @@
+package transition
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/ledger"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotAllowed is returned when the status rules forbid the transition
+var ErrNotAllowed = errors.New("status transition not allowed")
+
+// ErrExpired is returned when a provider reports on a payment that already expired,
+// only an operator can re-open it
+var ErrExpired = errors.New("payment expired")
+
+// Apply moves the payment an aggregator instance knows by update.TransactionID to the
+// reported status, following the status rules. Callbacks and the poller both go through
+// here, source says which one it was. It returns the payment and whether its status changed
+func Apply(db *database.Database, aggregator string, update payment.TransactionStatus, source string) (models.PaymentModel, bool, error) {
+	paymentDoc, err := db.FindPaymentByTransactionID(aggregator, update.TransactionID)
+	if err != nil {
+		return models.PaymentModel{}, false, err
+	}
+	if paymentDoc.Status == models.StatusExpired {
+		return paymentDoc, false, fmt.Errorf("%w: %s reported %s", ErrExpired, source, update.Status)
+	}
+	return To(db, paymentDoc, update.Status, source)
+}
+
+// conflictRetries is how many times To reloads a payment updated concurrently before giving up
+const conflictRetries = 3
+
+// To moves a loaded payment to the reported status, following the status rules. When the payment was
+// updated since it was loaded, it is reloaded and the rules are checked again
+func To(db *database.Database, paymentDoc models.PaymentModel, reported, source string) (models.PaymentModel, bool, error) {
+	for attempt := 1; ; attempt++ {
+		status := holdForReview(paymentDoc, reported)
+		if paymentDoc.Status == status || (status == models.StatusPending && paymentDoc.Status == models.StatusSuccess) {
+			return paymentDoc, false, nil
+		}
+		if !models.CanTransition(paymentDoc.Status, status) {
+			return paymentDoc, false, fmt.Errorf("%w: %s -> %s", ErrNotAllowed, paymentDoc.Status, status)
+		}
+
+		posting := models.PendingPostingModel{
+			ID:   primitive.NewObjectID(),
+			From: paymentDoc.Status,
+			To:   status,
+			At:   primitive.NewDateTimeFromTime(time.Now()),
+		}
+		hasPostings := ledger.Moves(paymentDoc, posting.From, posting.To) || releases(paymentDoc.TransactionType, status) ||
+			releasesLimits(paymentDoc, status)
+
+		var pending *models.PendingPostingModel
+		if hasPostings {
+			pending = &posting
+		}
+		updated, err := db.UpdatePaymentStatus(paymentDoc, status, pending)
+		if errors.Is(err, database.ErrConflict) && attempt < conflictRetries {
+			logger.WarningLogger.Printf("Payment %s changed while moving it to %s via %s, reloading", paymentDoc.ID.Hex(), status, source)
+			if paymentDoc, err = db.FindPaymentByID(paymentDoc.ID); err != nil {
+				return paymentDoc, false, err
+			}
+			continue
+		}
+		if err != nil {
+			return paymentDoc, false, err
+		}
+
+		logger.InfoLogger.Printf("Payment %s (%s): %s -> %s via %s", paymentDoc.ID.Hex(), paymentDoc.TransactionID, paymentDoc.Status, status, source)
+
+		if hasPostings {
+			// the pending posting was stored with the status, a failure here is retried by the PostingWorker
+			if err := Post(db, updated, posting); err != nil {
+				logger.ErrorLogger.Printf("Payment %s: postings for %s -> %s failed, leaving them for retry: %v", updated.ID.Hex(), posting.From, posting.To, err)
+			}
+		}
+		return updated, true, nil
+	}
+}
+
+// Post makes the postings of a payment's status transition: its journal, for a refund
+// that failed, giving its amount back to the deposit and, for a payment that failed or
+// expired, giving back its limit reservations. All are idempotent, so a pending posting
+// is only removed once they have all been made
+func Post(db *database.Database, paymentDoc models.PaymentModel, posting models.PendingPostingModel) error {
+	if err := ledger.Post(db, paymentDoc, posting.From, posting.To); err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+	if releases(paymentDoc.TransactionType, posting.To) {
+		if _, err := db.ReleaseRefund(paymentDoc); err != nil {
+			return fmt.Errorf("releasing refund on payment %s: %w", paymentDoc.OriginalPaymentID.Hex(), err)
+		}
+	}
+	if releasesLimits(paymentDoc, posting.To) {
+		if err := db.ReleasePaymentLimits(paymentDoc); err != nil {
+			return fmt.Errorf("releasing limits: %w", err)
+		}
+	}
+	return db.CompletePosting(paymentDoc.ID, posting)
+}
+
+// holdForReview is the status a payment moves to when status is reported: a deposit still
+// waiting in risk review is held rather than confirmed, so nothing is booked until it is approved
+func holdForReview(paymentDoc models.PaymentModel, status string) string {
+	if status == models.StatusConfirmed && paymentDoc.AwaitsReview() {
+		return models.StatusHeld
+	}
+	return status
+}
+
+// releases reports whether moving a payment to status gives a refund's amount back to its
+// deposit, however it got there: the refund call, a callback, the poller or an operator
+func releases(transactionType, status string) bool {
+	return transactionType == models.TypeRefund && status == models.StatusFailed
+}
+
+// releasesLimits reports whether moving a payment to status gives back what it reserved
+// on its limits: payments that failed or expired don't count towards them
+func releasesLimits(paymentDoc models.PaymentModel, status string) bool {
+	return len(paymentDoc.LimitCounters) > 0 && (status == models.StatusFailed || status == models.StatusExpired)
+}
@@ -0,0 +1,60 @@
+package transition
+
+import (
+	"testing"
+
+	"payment-aggregator/models"
+)
+
+func TestHoldForReview(t *testing.T) {
+	review := func(decision string, reviewed bool) models.PaymentModel {
+		p := models.PaymentModel{Status: models.StatusPending, Risk: &models.RiskModel{Decision: decision}}
+		if reviewed {
+			p.Risk.Review = &models.RiskReviewModel{Outcome: models.ReviewApproved}
+		}
+		return p
+	}
+	held := review(models.RiskReview, false)
+	held.Status = models.StatusHeld
+
+	tests := []struct {
+		name    string
+		payment models.PaymentModel
+		status  string
+		want    string
+	}{
+		{"not assessed", models.PaymentModel{Status: models.StatusPending}, models.StatusConfirmed, models.StatusConfirmed},
+		{"allowed", review(models.RiskAllow, false), models.StatusConfirmed, models.StatusConfirmed},
+		{"waiting for review", review(models.RiskReview, false), models.StatusConfirmed, models.StatusHeld},
+		{"waiting for review fails", review(models.RiskReview, false), models.StatusFailed, models.StatusFailed},
+		{"already held", held, models.StatusConfirmed, models.StatusHeld},
+		{"reviewed", review(models.RiskReview, true), models.StatusConfirmed, models.StatusConfirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := holdForReview(tt.payment, tt.status); got != tt.want {
+				t.Errorf("holdForReview(%s) = %s, want %s", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeldTransitions(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{models.StatusPending, models.StatusHeld, true},
+		{models.StatusSuccess, models.StatusHeld, true},
+		{models.StatusHeld, models.StatusConfirmed, true},
+		{models.StatusHeld, models.StatusFailed, false},
+		{models.StatusHeld, models.StatusExpired, false},
+		{models.StatusHeld, models.StatusRefunded, false},
+		{models.StatusConfirmed, models.StatusHeld, false},
+	}
+	for _, tt := range tests {
+		if got := models.CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package transition
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"payment-aggregator/internal/database"
+	"payment-aggregator/internal/logger"
+)
+
+// PostingWorker retries the postings of transitions that were stored but not posted,
+// e.g. because the process crashed or the database failed right after the status changed
+type PostingWorker struct {
+	db        *database.Database
+	interval  time.Duration
+	batchSize int64
+}
+
+// NewPostingWorker retries every POSTING_RETRY_INTERVAL (default a minute) the postings
+// pending for longer than that
+func NewPostingWorker(db *database.Database) *PostingWorker {
+	interval := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("POSTING_RETRY_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	return &PostingWorker{db: db, interval: interval, batchSize: 100}
+}
+
+// Run retries until ctx is cancelled
+func (w *PostingWorker) Run(ctx context.Context) {
+	logger.InfoLogger.Printf("Posting worker started, retrying every %s", w.interval)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.RetryOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			logger.InfoLogger.Println("Posting worker stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RetryOnce makes the postings pending for longer than the interval, returning how many were made
+func (w *PostingWorker) RetryOnce(ctx context.Context) int {
+	payments, err := w.db.ListPendingPostings(time.Now().Add(-w.interval), w.batchSize)
+	if err != nil {
+		logger.ErrorLogger.Printf("Postings: failed to list pending postings: %v", err)
+		return 0
+	}
+
+	posted := 0
+	for _, paymentDoc := range payments {
+		for _, posting := range paymentDoc.PendingPostings {
+			if ctx.Err() != nil {
+				return posted
+			}
+			if err := Post(w.db, paymentDoc, posting); err != nil {
+				logger.ErrorLogger.Printf("Postings: %s -> %s for payment %s failed again: %v", posting.From, posting.To, paymentDoc.ID.Hex(), err)
+				continue
+			}
+			logger.InfoLogger.Printf("Postings: posted %s -> %s for payment %s", posting.From, posting.To, paymentDoc.ID.Hex())
+			posted++
+		}
+	}
+	return posted
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AuditModel is one request to an aggregator and its response, as sent and received
+// apart from redacted secrets. Audit records are never updated
+type AuditModel struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Aggregator      string              `bson:"aggregator" json:"aggregator"`
+	CorrelationID   string              `bson:"correlation_id,omitempty" json:"correlation_id,omitempty"`
+	PaymentID       primitive.ObjectID  `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	Method          string              `bson:"method" json:"method"`
+	URL             string              `bson:"url" json:"url"`
+	RequestHeaders  map[string][]string `bson:"request_headers" json:"request_headers"`
+	RequestBody     string              `bson:"request_body,omitempty" json:"request_body,omitempty"`
+	StatusCode      int                 `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	ResponseHeaders map[string][]string `bson:"response_headers,omitempty" json:"response_headers,omitempty"`
+	ResponseBody    string              `bson:"response_body,omitempty" json:"response_body,omitempty"`
+	Error           string              `bson:"error,omitempty" json:"error,omitempty"`
+	LatencyMS       int64               `bson:"latency_ms" json:"latency_ms"`
+	SentAt          primitive.DateTime  `bson:"sent_at" json:"sent_at"`
+}
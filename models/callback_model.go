@@ -0,0 +1,31 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// verification results of a stored callback
+const (
+	CallbackVerified     = "verified"
+	CallbackUnverifiable = "unverifiable" // the adapter has no way to verify callbacks
+	CallbackRejected     = "rejected"
+)
+
+// CallbackModel is a raw inbound callback as received, kept so it can be replayed
+type CallbackModel struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Aggregator   string              `bson:"aggregator,omitempty" json:"aggregator,omitempty"` // instance named in the URL, if any
+	Method       string              `bson:"method" json:"method"`
+	Headers      map[string][]string `bson:"headers" json:"headers"`
+	Body         string              `bson:"body" json:"body"`
+	SourceIP     string              `bson:"source_ip" json:"source_ip"`
+	ReceivedAt   primitive.DateTime  `bson:"received_at" json:"received_at"`
+	Verification string              `bson:"verification,omitempty" json:"verification,omitempty"`
+
+	// set once processed, and again on every replay
+	Result        string             `bson:"result,omitempty" json:"result,omitempty"`
+	Error         string             `bson:"error,omitempty" json:"error,omitempty"`
+	TransactionID string             `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	Status        string             `bson:"status,omitempty" json:"status,omitempty"`
+	PaymentID     primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	ProcessedAt   primitive.DateTime `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+	Replays       int                `bson:"replays,omitempty" json:"replays,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+// FeeScheduleModel is fixed + percentage of the amount, optionally tiered by monthly volume
+// and capped by Min and Max (0 means no cap)
+type FeeScheduleModel struct {
+	Fixed      float64        `bson:"fixed" json:"fixed"`
+	Percentage float64        `bson:"percentage" json:"percentage"` // 1.5 means 1.5%
+	Min        float64        `bson:"min,omitempty" json:"min,omitempty"`
+	Max        float64        `bson:"max,omitempty" json:"max,omitempty"`
+	Tiers      []FeeTierModel `bson:"tiers,omitempty" json:"tiers,omitempty"`
+}
+
+// FeeTierModel replaces the schedule's fixed and percentage parts
+// once the monthly volume reaches FromVolume
+type FeeTierModel struct {
+	FromVolume float64 `bson:"from_volume" json:"from_volume"`
+	Fixed      float64 `bson:"fixed" json:"fixed"`
+	Percentage float64 `bson:"percentage" json:"percentage"`
+}
+
+// FeesModel is the fee breakdown stored on a payment. Our fee is charged to the merchant,
+// the provider fee is the aggregator's commission and is our cost
+type FeesModel struct {
+	Gross       float64 `bson:"gross" json:"gross"`
+	ProviderFee float64 `bson:"provider_fee" json:"provider_fee"`
+	Fee         float64 `bson:"fee" json:"fee"`
+	Net         float64 `bson:"net" json:"net"` // owed to the merchant
+}
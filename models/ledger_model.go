@@ -0,0 +1,20 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// JournalModel is one balanced ledger posting. Journals are never updated or deleted,
+// corrections are posted as new journals
+type JournalModel struct {
+	ID          string             `bson:"_id" json:"id"` // <payment id>:<from>:<to>, so a transition posts once
+	PaymentID   primitive.ObjectID `bson:"payment_id" json:"payment_id"`
+	MerchantID  primitive.ObjectID `bson:"merchant_id,omitempty" json:"merchant_id,omitempty"`
+	Description string             `bson:"description" json:"description"`
+	Lines       []LedgerLineModel  `bson:"lines" json:"lines"`
+	PostedAt    primitive.DateTime `bson:"posted_at" json:"posted_at"`
+}
+
+// LedgerLineModel moves Amount on an account, debits positive and credits negative
+type LedgerLineModel struct {
+	Account string  `bson:"account" json:"account"`
+	Amount  float64 `bson:"amount" json:"amount"`
+}
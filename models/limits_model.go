@@ -0,0 +1,12 @@
+package models
+
+// TransactionLimitsModel caps transactions of one type, zero fields don't limit.
+// Days and months follow Europe/Istanbul business days
+type TransactionLimitsModel struct {
+	MinAmount     float64 `bson:"min_amount,omitempty" json:"min_amount,omitempty"` // per transaction
+	MaxAmount     float64 `bson:"max_amount,omitempty" json:"max_amount,omitempty"`
+	DailyAmount   float64 `bson:"daily_amount,omitempty" json:"daily_amount,omitempty"`
+	MonthlyAmount float64 `bson:"monthly_amount,omitempty" json:"monthly_amount,omitempty"`
+	DailyCount    int64   `bson:"daily_count,omitempty" json:"daily_count,omitempty"`
+	MonthlyCount  int64   `bson:"monthly_count,omitempty" json:"monthly_count,omitempty"`
+}
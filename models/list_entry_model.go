@@ -0,0 +1,32 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// lists
+const (
+	ListBlock = "block" // transactions matching the entry are refused
+	ListAllow = "allow" // transactions matching the entry are only denied by deny-level risk rules
+)
+
+// what a list entry matches
+const (
+	ListKindIBAN  = "iban"
+	ListKindPayer = "payer" // payer or account holder name
+	ListKindBank  = "bank"
+)
+
+// ListEntryModel is one IBAN, payer or bank on the blocklist or allowlist.
+// Entries are never deleted: expired and removed ones are kept with their reasons
+type ListEntryModel struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	List       string             `bson:"list" json:"list"`
+	Kind       string             `bson:"kind" json:"kind"`
+	Value      string             `bson:"value" json:"value"`           // as entered
+	Normalized string             `bson:"normalized" json:"normalized"` // as matched
+	Reason     string             `bson:"reason" json:"reason"`
+	CreatedBy  string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt  primitive.DateTime `bson:"created_at" json:"created_at"`
+	ExpiresAt  primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // never when unset
+	RemovedBy  string             `bson:"removed_by,omitempty" json:"removed_by,omitempty"`
+	RemovedAt  primitive.DateTime `bson:"removed_at,omitempty" json:"removed_at,omitempty"`
+}
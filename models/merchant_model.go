@@ -0,0 +1,50 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// merchant statuses, only active merchants can authenticate
+const (
+	MerchantActive    = "active"
+	MerchantSuspended = "suspended"
+)
+
+type MerchantModel struct {
+	ID                 primitive.ObjectID                `bson:"_id,omitempty" json:"id"`
+	Name               string                            `bson:"name" json:"name"`
+	Status             string                            `bson:"status" json:"status"`
+	APIKeys            []APIKeyModel                     `bson:"api_keys" json:"api_keys"`
+	CallbackURL        string                            `bson:"callback_url" json:"callback_url"`
+	AllowedAggregators []string                          `bson:"allowed_aggregators" json:"allowed_aggregators"`                   // instance names, empty allows all
+	RateLimits         map[string]RateLimitModel         `bson:"rate_limits,omitempty" json:"rate_limits,omitempty"`               // by endpoint, "default" for the others
+	Fees               map[string]FeeScheduleModel       `bson:"fees,omitempty" json:"fees,omitempty"`                             // by aggregator instance, "default" for the others
+	MaxConcurrent      int                               `bson:"max_concurrent,omitempty" json:"max_concurrent,omitempty"`         // in-flight deposits, 0 uses the default
+	TransactionLimits  map[string]TransactionLimitsModel `bson:"transaction_limits,omitempty" json:"transaction_limits,omitempty"` // by transaction type
+	CreatedAt          primitive.DateTime                `bson:"created_at" json:"created_at"`
+}
+
+// APIKeyModel stores only the hash of a key, the plain key is shown once when issued
+type APIKeyModel struct {
+	Prefix    string             `bson:"prefix" json:"prefix"` // first characters, to tell keys apart
+	Hash      string             `bson:"hash" json:"-"`
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	RevokedAt primitive.DateTime `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// RateLimitModel is a token bucket refilled at RequestsPerSecond up to Burst
+type RateLimitModel struct {
+	RequestsPerSecond float64 `bson:"requests_per_second" json:"requests_per_second"`
+	Burst             int     `bson:"burst" json:"burst"`
+}
+
+// AllowsAggregator reports whether the merchant may use the aggregator instance
+func (m MerchantModel) AllowsAggregator(name string) bool {
+	if len(m.AllowedAggregators) == 0 {
+		return true
+	}
+	for _, allowed := range m.AllowedAggregators {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
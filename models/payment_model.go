@@ -4,13 +4,75 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type PaymentModel struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MerchantID      primitive.ObjectID `bson:"merchant_id,omitempty" json:"merchant_id,omitempty"`
 	TransactionID   string             `bson:"transaction_id" json:"transaction_id"`
 	Amount          float64            `bson:"amount" json:"amount"`
 	Status          string             `bson:"status" json:"status"`
 	TransactionType string             `bson:"transaction_type" json:"transaction_type"`
 	PayerName       string             `bson:"payer_name" json:"payer_name"`
+	PayerIBAN       string             `bson:"payer_iban,omitempty" json:"payer_iban,omitempty"` // as declared by the merchant, normalized
 	IBAN            string             `bson:"iban" json:"iban"`
 	BankName        string             `bson:"bank_name" json:"bank_name"`
 	Aggregator      string             `bson:"aggregator" json:"aggregator"`
-	CreatedAt       primitive.DateTime `bson:"created_at" json:"created_at"`
+	Fees            *FeesModel         `bson:"fees,omitempty" json:"fees,omitempty"`
+	Risk            *RiskModel         `bson:"risk,omitempty" json:"risk,omitempty"`
+
+	// refunds link to the deposit they return money from,
+	// which keeps the total refunded so far
+	OriginalPaymentID primitive.ObjectID   `bson:"original_payment_id,omitempty" json:"original_payment_id,omitempty"`
+	RefundedAmount    float64              `bson:"refunded_amount,omitempty" json:"refunded_amount,omitempty"`
+	ReleasedRefunds   []primitive.ObjectID `bson:"released_refunds,omitempty" json:"-"` // failed refunds whose amount was given back
+	Reason            string               `bson:"reason,omitempty" json:"reason,omitempty"`
+
+	Attempts []AttemptModel `bson:"attempts,omitempty" json:"attempts,omitempty"`
+
+	// matches the audit records of the provider calls made for the payment,
+	// also kept on deposits that failed and were never stored
+	CorrelationID string `bson:"correlation_id,omitempty" json:"correlation_id,omitempty"`
+
+	// status polling for providers whose callbacks don't arrive
+	PollAttempts int                `bson:"poll_attempts,omitempty" json:"poll_attempts,omitempty"`
+	NextPollAt   primitive.DateTime `bson:"next_poll_at,omitempty" json:"next_poll_at,omitempty"`
+
+	// limit counters the payment is reserved on, given back if it fails or expires
+	LimitCounters []string `bson:"limit_counters,omitempty" json:"-"`
+
+	// pending deposits expire when the payer hasn't paid by then
+	ExpiresAt primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+
+	// transitions whose journal, or released refund, is still to be posted
+	PendingPostings []PendingPostingModel `bson:"pending_postings,omitempty" json:"-"`
+
+	// bumped by every update, so concurrent writers can't overwrite each other
+	Version int64 `bson:"version" json:"version"`
+
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// RefundableAmount is how much of a confirmed deposit can still be refunded
+func (p PaymentModel) RefundableAmount() float64 {
+	if p.TransactionType != TypeDeposit || (p.Status != StatusConfirmed && p.Status != StatusPartiallyRefunded) {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
+
+// PendingPostingModel marks a status transition whose postings haven't been made yet.
+// It is stored with the status change itself and removed once they are, so a crash in
+// between leaves it for the posting worker to retry
+type PendingPostingModel struct {
+	ID   primitive.ObjectID `bson:"id,omitempty" json:"id"` // unset on postings stored before they had one
+	From string             `bson:"from" json:"from"`
+	To   string             `bson:"to" json:"to"`
+	At   primitive.DateTime `bson:"at" json:"at"`
+}
+
+// AttemptModel records one aggregator tried while running a flow
+type AttemptModel struct {
+	Aggregator  string             `bson:"aggregator" json:"aggregator"`
+	Outcome     string             `bson:"outcome" json:"outcome"` // succeeded, failed or skipped
+	Stage       string             `bson:"stage,omitempty" json:"stage,omitempty"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	AttemptedAt primitive.DateTime `bson:"attempted_at" json:"attempted_at"`
 }
@@ -0,0 +1,48 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// risk decisions
+const (
+	RiskAllow  = "allow"
+	RiskReview = "review" // made, but not confirmed until an operator approves it in the review queue
+	RiskDeny   = "deny"
+)
+
+// review outcomes
+const (
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// RiskModel is the risk assessment a payment was made with
+type RiskModel struct {
+	Score         int                `bson:"score" json:"score"`
+	Decision      string             `bson:"decision" json:"decision"`
+	Hits          []RiskHitModel     `bson:"hits,omitempty" json:"hits,omitempty"`
+	DeclaredPayer string             `bson:"declared_payer,omitempty" json:"declared_payer,omitempty"` // payer name the merchant sent
+	AllowedBy     primitive.ObjectID `bson:"allowed_by,omitempty" json:"allowed_by,omitempty"`         // allowlist entry that exempted it from score-level rules
+	AssessedAt    primitive.DateTime `bson:"assessed_at" json:"assessed_at"`
+
+	Review *RiskReviewModel `bson:"review,omitempty" json:"review,omitempty"`
+}
+
+// RiskHitModel is one rule that scored the payment
+type RiskHitModel struct {
+	Rule   string `bson:"rule" json:"rule"`
+	Score  int    `bson:"score" json:"score"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+// RiskReviewModel is an operator's decision on a payment in the review queue
+type RiskReviewModel struct {
+	Outcome    string             `bson:"outcome" json:"outcome"` // approved or rejected
+	Reviewer   string             `bson:"reviewer,omitempty" json:"reviewer,omitempty"`
+	Note       string             `bson:"note,omitempty" json:"note,omitempty"`
+	ReviewedAt primitive.DateTime `bson:"reviewed_at" json:"reviewed_at"`
+}
+
+// AwaitsReview reports whether the payment is in the review queue with no decision yet
+func (p PaymentModel) AwaitsReview() bool {
+	return p.Risk != nil && p.Risk.Decision == RiskReview && p.Risk.Review == nil
+}
@@ -0,0 +1,51 @@
+package models
+
+// transaction types
+const (
+	TypeDeposit    = "deposit"
+	TypeWithdrawal = "withdrawal"
+	TypeRefund     = "refund"
+)
+
+// payment statuses
+const (
+	StatusPending           = "pending"   // created at the provider, waiting for the money
+	StatusConfirmed         = "confirmed" // money received (or sent, for refunds)
+	StatusFailed            = "failed"    // rejected or cancelled
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+	StatusPendingPayout     = "pending_payout" // refund the provider can't do, waiting for a manual payout
+	StatusExpired           = "expired"        // deposit the payer never paid within the aggregator's window
+	StatusHeld              = "held"           // deposit paid while waiting in risk review, booked once approved
+	StatusUnresolved        = "unresolved"     // legacy deposit whose outcome was never reported, neither polled nor expired
+
+	// StatusSuccess was stored for deposits before statuses were tracked, it is treated like pending
+	StatusSuccess = "success"
+)
+
+// statusTransitions are the status rules: the statuses a payment may move to from each status
+var statusTransitions = map[string][]string{
+	StatusPending:           {StatusConfirmed, StatusHeld, StatusFailed, StatusExpired},
+	StatusSuccess:           {StatusConfirmed, StatusHeld, StatusFailed, StatusExpired},
+	StatusHeld:              {StatusConfirmed}, // only once reviewed
+	StatusUnresolved:        {StatusConfirmed, StatusFailed},
+	StatusConfirmed:         {StatusPartiallyRefunded, StatusRefunded},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+	StatusPendingPayout:     {StatusConfirmed, StatusFailed},
+	StatusExpired:           {StatusPending}, // only when an operator re-opens it
+}
+
+// CanTransition reports whether the status rules allow moving from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether no further transition is possible from status
+func IsFinal(status string) bool {
+	return len(statusTransitions[status]) == 0
+}
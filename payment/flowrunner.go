@@ -1,9 +1,138 @@
 package payment
 
-import "payment-aggregator/models"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"payment-aggregator/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // to direct the flow between interactive vs simple
 // without coupling it with main
 type FlowRunner interface {
-	RunDepositFlow(amount float64) (DepositResponse, models.PaymentModel, error)
+	RunDepositFlow(ctx context.Context, req DepositRequest) (DepositResponse, models.PaymentModel, error)
+}
+
+// DepositRequest is everything a deposit flow needs from its caller
+type DepositRequest struct {
+	Amount             float64
+	MerchantID         primitive.ObjectID
+	PayerIBAN          string          // as declared by the merchant, empty if not
+	AllowedAggregators []string        // instance names the merchant may use, empty allows all
+	SelectAccount      AccountSelector // nil asks on the terminal
+
+	// Check runs once the account is chosen, right before the money-moving call,
+	// with the payment about to be made. An error stops the flow at StageChecks
+	Check DepositCheck
+}
+
+// DepositCheck screens a deposit before it is made, returning a *Rejection to refuse it
+type DepositCheck func(ctx context.Context, candidate models.PaymentModel) error
+
+// AllChecks runs checks in order, stopping at the first that refuses the deposit
+func AllChecks(checks ...DepositCheck) DepositCheck {
+	return func(ctx context.Context, candidate models.PaymentModel) error {
+		for _, check := range checks {
+			if err := check(ctx, candidate); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Rejection is a deposit refused by our own rules rather than by the provider,
+// Reason is returned to the caller
+type Rejection struct {
+	Rule   string // e.g. "limits.merchant.daily_amount"
+	Reason string
+	Scope  string // ScopeAggregator when only the aggregator it was checked on refuses it
+}
+
+// ScopeAggregator marks a rejection by the rules of one aggregator, such as its own
+// limits, which the next aggregator in the chain may not make
+const ScopeAggregator = "aggregator"
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+// AccountSelector picks which of the provider's bank accounts the payer deposits to,
+// returning its index
+type AccountSelector func(accounts []map[string]interface{}) (int, error)
+
+// SelectFirst picks the first account offered by the provider
+func SelectFirst(accounts []map[string]interface{}) (int, error) {
+	if len(accounts) == 0 {
+		return 0, fmt.Errorf("no accounts available")
+	}
+	return 0, nil
+}
+
+// SelectByID picks the account with the given _id
+func SelectByID(id string) AccountSelector {
+	return func(accounts []map[string]interface{}) (int, error) {
+		for i, account := range accounts {
+			if accountID, _ := account["_id"].(string); accountID == id {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("account %s not offered", id)
+	}
+}
+
+// HealthChecker can optionally be implemented by a FlowRunner
+// so the router can skip it while it is marked unhealthy
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// stages of a deposit flow, in the order they run
+const (
+	StageSession  = "session"
+	StageAccounts = "accounts"
+	StageChecks   = "checks"
+	StageDeposit  = "deposit"
+)
+
+// FlowError tags a flow failure with the stage it happened in
+type FlowError struct {
+	Stage string
+	Err   error
+}
+
+func (e *FlowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FlowError) Unwrap() error {
+	return e.Err
+}
+
+// CanFailover reports whether err happened before any money-moving call and
+// another aggregator could succeed, so the flow can be retried on it. Checks
+// refusing the deposit fail over only when the aggregator's own rules refused it
+func CanFailover(err error) bool {
+	var flowErr *FlowError
+	if !errors.As(err, &flowErr) {
+		return false
+	}
+	switch flowErr.Stage {
+	case StageSession, StageAccounts:
+		return true
+	case StageChecks:
+		var rejection *Rejection
+		return errors.As(err, &rejection) && rejection.Scope == ScopeAggregator
+	}
+	return false
+}
+
+// IsProviderFailure reports whether err was raised while talking to the provider,
+// as opposed to invalid input or a deposit our own checks refused
+func IsProviderFailure(err error) bool {
+	var flowErr *FlowError
+	return errors.As(err, &flowErr) && flowErr.Stage != StageChecks
 }
@@ -0,0 +1,33 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCanFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"session failed", &FlowError{Stage: StageSession, Err: errors.New("timeout")}, true},
+		{"accounts failed", &FlowError{Stage: StageAccounts, Err: errors.New("no accounts")}, true},
+		{"aggregator limit", &FlowError{Stage: StageChecks, Err: &Rejection{Rule: "limits.aggregator.daily_amount", Scope: ScopeAggregator}}, true},
+		{"merchant limit", &FlowError{Stage: StageChecks, Err: &Rejection{Rule: "limits.merchant.daily_amount"}}, false},
+		{"payer IBAN limit", &FlowError{Stage: StageChecks, Err: &Rejection{Rule: "limits.iban.daily_count"}}, false},
+		{"risk deny", &FlowError{Stage: StageChecks, Err: &Rejection{Rule: "risk.deny"}}, false},
+		{"check could not run", &FlowError{Stage: StageChecks, Err: errors.New("mongo down")}, false},
+		{"deposit call failed", &FlowError{Stage: StageDeposit, Err: errors.New("timeout")}, false},
+		{"wrapped", fmt.Errorf("route deposit: %w", &FlowError{Stage: StageAccounts, Err: errors.New("no accounts")}), true},
+		{"not a flow error", errors.New("invalid selection"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanFailover(tt.err); got != tt.want {
+				t.Fatalf("CanFailover = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
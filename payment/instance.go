@@ -0,0 +1,39 @@
+package payment
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// InstanceConfig is one configured account of an aggregator type.
+// Several instances of the same type can run side by side, each reading
+// its settings from environment variables starting with its own prefix
+type InstanceConfig struct {
+	Name   string `json:"name"`   // used in routing and recorded on payments
+	Type   string `json:"type"`   // registered adapter name
+	Prefix string `json:"prefix"` // upper-cased name with - as _, e.g. BRAND_A for brand-a
+}
+
+// Get returns the instance setting <Prefix>_<key>
+func (c InstanceConfig) Get(key string) string {
+	return os.Getenv(c.Prefix + "_" + key)
+}
+
+// GetFloat returns a numeric setting, falling back to def when missing or invalid
+func (c InstanceConfig) GetFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(c.Get(key), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// GetDuration returns a duration setting such as "15m", falling back to def
+func (c InstanceConfig) GetDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(c.Get(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
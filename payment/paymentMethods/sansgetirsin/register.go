@@ -0,0 +1,33 @@
+package sansgetirsin
+
+import "payment-aggregator/payment"
+
+const (
+	Name        = "sansgetirsin"
+	DisplayName = "Sans Getirsin"
+)
+
+func init() {
+	payment.Register(payment.Descriptor{
+		Name:        Name,
+		DisplayName: DisplayName,
+		Config: []payment.ConfigField{
+			{Name: "KEY", Description: "account key used in the API host name", Required: true},
+			{Name: "USERNAME", Description: "API username", Required: true},
+			{Name: "API_KEY", Description: "API key", Required: true, Secret: true},
+			{Name: "USER_ID", Description: "user ID sent with every session", Required: true},
+			{Name: "CALLBACK_SECRET", Description: "signs callbacks, none are applied without it", Required: true, Secret: true},
+			{Name: "PAYMENT_METHOD", Description: "payment method ID", Default: "1"},
+			{Name: "MAX_WITHDRAW_LIMIT", Description: "withdraw limit sent to the provider", Default: "1000"},
+			{Name: "TOKEN_TTL", Description: "session lifetime when the provider sends none", Default: "15m"},
+			{Name: "TOKEN_REFRESH_BEFORE", Description: "how long before expiry to refresh the session", Default: "1m"},
+		},
+		Capabilities: []payment.Capability{
+			payment.CapabilityDeposit,
+			payment.CapabilityStatusQuery,
+			payment.CapabilityCallbacks,
+		},
+		Currencies: []string{"TRY"},
+		New:        New,
+	})
+}
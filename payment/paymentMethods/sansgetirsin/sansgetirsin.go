@@ -2,22 +2,32 @@ package sansgetirsin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"payment-aggregator/internal/audit"
 	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/metrics"
+	"payment-aggregator/internal/session"
+	"payment-aggregator/internal/tracing"
 	"payment-aggregator/models"
 	"payment-aggregator/payment"
-	"strconv"
+	"time"
 )
 
 type SansgetirsinAggregator struct {
+	Instance       string // configured instance name, recorded on payments
 	BaseURL        string
 	Username       string
 	APIKey         string
 	AdditionalData map[string]interface{}
+	TokenTTL       time.Duration // used when the session response carries no expiry
+	CallbackSecret string        // signs the provider's callbacks, none are accepted without it
+
+	tokens *session.Manager
+	client *http.Client
 }
 
 func NewSansgetirsinAggregator(baseURL string) *SansgetirsinAggregator {
@@ -26,41 +36,91 @@ func NewSansgetirsinAggregator(baseURL string) *SansgetirsinAggregator {
 
 var _ payment.Aggregator = &SansgetirsinAggregator{}
 
-// NewFromEnv creates a new SansgetirsinAggregator instance from environment variables
+// NewFromEnv creates a new SansgetirsinAggregator instance from the SANSGETIRSIN_* environment variables
 func NewFromEnv() payment.FlowRunner {
-	baseURL := fmt.Sprintf("https://api-%s.sansgetirsin.com", os.Getenv("SANSGETIRSIN_KEY"))
-	logger.InfoLogger.Println("Constructed BaseURL:", baseURL)
-	return &SansgetirsinAggregator{
+	return New(payment.InstanceConfig{Name: Name, Type: Name, Prefix: "SANSGETIRSIN"})
+}
+
+// New creates a SansgetirsinAggregator for one configured instance,
+// reading its settings from <PREFIX>_* environment variables
+func New(instance payment.InstanceConfig) payment.FlowRunner {
+	baseURL := fmt.Sprintf("https://api-%s.sansgetirsin.com", instance.Get("KEY"))
+	logger.InfoLogger.Printf("Constructed BaseURL for %s: %s", instance.Name, baseURL)
+	s := &SansgetirsinAggregator{
+		Instance: instance.Name,
 		BaseURL:  baseURL,
-		Username: os.Getenv("SANSGETIRSIN_USERNAME"),
-		APIKey:   os.Getenv("SANSGETIRSIN_API_KEY"),
+		Username: instance.Get("USERNAME"),
+		APIKey:   instance.Get("API_KEY"),
 		AdditionalData: map[string]interface{}{
-			"userId":           os.Getenv("SANSGETIRSIN_USER_ID"),
-			"paymentMethod":    mustParseFloat(os.Getenv("SANSGETIRSIN_PAYMENT_METHOD"), 1),
-			"maxWithdrawLimit": mustParseFloat(os.Getenv("SANSGETIRSIN_MAX_WITHDRAW_LIMIT"), 1000),
+			"userId":           instance.Get("USER_ID"),
+			"paymentMethod":    instance.GetFloat("PAYMENT_METHOD", 1),
+			"maxWithdrawLimit": instance.GetFloat("MAX_WITHDRAW_LIMIT", 1000),
 		},
+		TokenTTL:       instance.GetDuration("TOKEN_TTL", 15*time.Minute),
+		CallbackSecret: instance.Get("CALLBACK_SECRET"),
 	}
+	s.client = newHTTPClient(instance.Name)
+	s.tokens = session.NewManager(instance.Name, s.fetchSession, instance.GetDuration("TOKEN_REFRESH_BEFORE", time.Minute))
+	return s
 }
 
-// helper to safely parse a string into an int,
-// falling back to a default if parsing fails.
-func mustParseFloat(s string, def float64) float64 {
-	f, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		return def
+// zero-arg InitializeSession method, returns the cached session token
+// and only posts credentials again when it is missing or expiring
+func (s *SansgetirsinAggregator) InitializeSession() (string, error) {
+	return s.sessionToken(context.Background())
+}
+
+// sessionToken is InitializeSession for callers with a context
+func (s *SansgetirsinAggregator) sessionToken(ctx context.Context) (string, error) {
+	if s.tokens == nil {
+		token, _, err := s.initializeSession(ctx, s.Username, s.APIKey, s.AdditionalData)
+		return token, err
 	}
-	return f
+	return s.tokens.Token(ctx)
 }
 
-// zero-arg InitializeSession method that uses the one with arguments as a helper
-func (s *SansgetirsinAggregator) InitializeSession() (string, error) {
-	return s.InitializeSessionWithParams(s.Username, s.APIKey, s.AdditionalData)
+// withToken runs fn with the session token, retrying once with a fresh one on 401
+func (s *SansgetirsinAggregator) withToken(ctx context.Context, fn func(token string) error) error {
+	if s.tokens == nil {
+		token, err := s.sessionToken(ctx)
+		if err != nil {
+			return err
+		}
+		return fn(token)
+	}
+	return s.tokens.Do(ctx, fn)
+}
+
+// newHTTPClient times, traces and audits the calls an instance makes
+func newHTTPClient(instance string) *http.Client {
+	return &http.Client{Transport: tracing.Transport(&metrics.Transport{
+		Aggregator: instance,
+		Base:       &audit.Transport{Aggregator: instance},
+	})}
+}
+
+// httpClient returns the instance's instrumented client
+func (s *SansgetirsinAggregator) httpClient() *http.Client {
+	if s.client == nil {
+		return newHTTPClient(s.instanceName())
+	}
+	return s.client
+}
+
+// fetchSession is the token manager's fetcher
+func (s *SansgetirsinAggregator) fetchSession(ctx context.Context) (string, time.Time, error) {
+	return s.initializeSession(ctx, s.Username, s.APIKey, s.AdditionalData)
 }
 
 // initialize session with args
 func (s *SansgetirsinAggregator) InitializeSessionWithParams(username, apiKey string, additionalData map[string]interface{}) (string, error) {
+	token, _, err := s.initializeSession(context.Background(), username, apiKey, additionalData)
+	return token, err
+}
+
+func (s *SansgetirsinAggregator) initializeSession(ctx context.Context, username, apiKey string, additionalData map[string]interface{}) (string, time.Time, error) {
 	logger.InfoLogger.Println("Sansgetirsin: Initializing session...")
-	client := &http.Client{}
+	client := s.httpClient()
 	sessionURL := s.BaseURL + "/payment/json"
 
 	requestBody, err := json.Marshal(map[string]interface{}{
@@ -70,60 +130,70 @@ func (s *SansgetirsinAggregator) InitializeSessionWithParams(username, apiKey st
 	})
 	if err != nil {
 		logger.ErrorLogger.Printf("Sansgetirsin: Failed to marshal session request: %v", err)
-		return "", fmt.Errorf("failed to marshal session request: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to marshal session request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", sessionURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", sessionURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		logger.ErrorLogger.Printf("Sansgetirsin: Failed to create session request: %v", err)
-		return "", fmt.Errorf("failed to create session request: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to create session request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.ErrorLogger.Printf("Sansgetirsin: Session request failed: %v", err)
-		return "", fmt.Errorf("session request failed: %w", err)
+		return "", time.Time{}, fmt.Errorf("session request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.ErrorLogger.Printf("Sansgetirsin: Failed to read session response: %v", err)
-		return "", fmt.Errorf("failed to read session response: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to read session response: %w", err)
 	}
 
 	var jsonResponse map[string]interface{}
 	err = json.Unmarshal(respBody, &jsonResponse)
 	if err != nil {
 		logger.ErrorLogger.Printf("Sansgetirsin: Failed to unmarshal session response: %v", err)
-		return "", fmt.Errorf("failed to unmarshal session response: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to unmarshal session response: %w", err)
 	}
 
 	data, ok := jsonResponse["data"].(map[string]interface{})
 	if !ok {
 		logger.ErrorLogger.Println("Sansgetirsin: Data field not found in response")
-		return "", fmt.Errorf("data field not found in response")
+		return "", time.Time{}, fmt.Errorf("data field not found in response")
 	}
 
 	token, ok := data["token"].(string)
 	if !ok {
 		logger.ErrorLogger.Println("Sansgetirsin: Session token not found in response data")
-		return "", fmt.Errorf("session token not found in response data")
+		return "", time.Time{}, fmt.Errorf("session token not found in response data")
+	}
+
+	// the provider may send the token lifetime, otherwise assume the configured TTL
+	expiresAt := time.Now().Add(s.TokenTTL)
+	if expiresIn, ok := data["expiresIn"].(float64); ok && expiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
 	}
 
 	logger.InfoLogger.Println("Sansgetirsin: Session initialized successfully.")
-	return token, nil
+	return token, expiresAt, nil
 }
 
 func (s *SansgetirsinAggregator) GetAccounts(token string, amount float64) ([]map[string]interface{}, error) {
+	return s.getAccounts(context.Background(), token, amount)
+}
+
+func (s *SansgetirsinAggregator) getAccounts(ctx context.Context, token string, amount float64) ([]map[string]interface{}, error) {
 	logger.InfoLogger.Println("Sansgetirsin: Getting accounts...")
-	client := &http.Client{}
+	client := s.httpClient()
 
 	// Construct the request URL (adjust based on API docs)
 	accountsURL := fmt.Sprintf("%s/payment/deposit?amount=%.2f", s.BaseURL, amount)
 
-	req, err := http.NewRequest("GET", accountsURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", accountsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -137,6 +207,10 @@ func (s *SansgetirsinAggregator) GetAccounts(token string, amount float64) ([]ma
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("sansgetirsin accounts request: %w", session.ErrUnauthorized)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
@@ -181,6 +255,10 @@ func (s *SansgetirsinAggregator) MakeDeposit(amount float64) (payment.DepositRes
 
 // MakeDepositWithData makes a deposit to the specified bank account
 func (s *SansgetirsinAggregator) MakeDepositWithData(token string, bankID string, amount float64, extraData map[string]interface{}) (payment.DepositResponse, error) {
+	return s.makeDeposit(context.Background(), token, bankID, amount, extraData)
+}
+
+func (s *SansgetirsinAggregator) makeDeposit(ctx context.Context, token string, bankID string, amount float64, extraData map[string]interface{}) (payment.DepositResponse, error) {
 	logger.InfoLogger.Println("Sansgetirsin: Making deposit...")
 
 	// Construct the request payload (adjust based on API docs)
@@ -203,7 +281,7 @@ func (s *SansgetirsinAggregator) MakeDepositWithData(token string, bankID string
 	depositURL := fmt.Sprintf("%s/payment/deposit", s.BaseURL)
 
 	// Create the HTTP request
-	req, err := http.NewRequest("POST", depositURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", depositURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return payment.DepositResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -213,13 +291,18 @@ func (s *SansgetirsinAggregator) MakeDepositWithData(token string, bankID string
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	// Make the HTTP request
-	client := &http.Client{}
+	client := s.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return payment.DepositResponse{}, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	// rejected before processing, safe to retry with a fresh token
+	if resp.StatusCode == http.StatusUnauthorized {
+		return payment.DepositResponse{}, fmt.Errorf("sansgetirsin deposit request: %w", session.ErrUnauthorized)
+	}
+
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -261,6 +344,7 @@ func (s *SansgetirsinAggregator) MakeDepositWithData(token string, bankID string
 	depositResponse := payment.DepositResponse{
 		Status:        status,
 		TransactionID: transactionID,
+		Amount:        amount,
 		Message:       message,
 	}
 
@@ -270,6 +354,6 @@ func (s *SansgetirsinAggregator) MakeDepositWithData(token string, bankID string
 	return depositResponse, nil
 }
 
-func (s *SansgetirsinAggregator) RunDepositFlow(amount float64) (payment.DepositResponse, models.PaymentModel, error) {
-	return s.InteractiveDepositFlow(amount)
+func (s *SansgetirsinAggregator) RunDepositFlow(ctx context.Context, req payment.DepositRequest) (payment.DepositResponse, models.PaymentModel, error) {
+	return s.DepositFlow(ctx, req)
 }
@@ -2,72 +2,69 @@ package sansgetirsin
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/tracing"
 	"payment-aggregator/models"
 	"payment-aggregator/payment"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// InteractiveDepositFlow asks on the terminal which account to deposit to
 func (s *SansgetirsinAggregator) InteractiveDepositFlow(amount float64) (payment.DepositResponse, models.PaymentModel, error) {
+	return s.DepositFlow(context.Background(), payment.DepositRequest{Amount: amount, SelectAccount: PromptAccount})
+}
+
+// DepositFlow runs a deposit, letting req.SelectAccount pick the bank account
+func (s *SansgetirsinAggregator) DepositFlow(ctx context.Context, req payment.DepositRequest) (payment.DepositResponse, models.PaymentModel, error) {
+	amount := req.Amount
+	attrs := []attribute.KeyValue{attribute.String("aggregator", s.instanceName()), attribute.Float64("amount", amount)}
 
-	// Initialize session and get accounts
-	token, err := s.InitializeSession()
+	// Initialize session (cached between flows) and get accounts
+	sessionCtx, span := tracing.Start(ctx, "InitializeSession", attrs...)
+	_, err := s.sessionToken(sessionCtx)
+	tracing.End(span, err)
 	if err != nil {
-		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("failed to initialize session: %w", err)
+		return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{Stage: payment.StageSession, Err: fmt.Errorf("failed to initialize session: %w", err)}
 	}
 
-	accounts, err := s.GetAccounts(token, amount)
+	var accounts []map[string]interface{}
+	accountsCtx, span := tracing.Start(ctx, "GetAccounts", attrs...)
+	err = s.withToken(accountsCtx, func(token string) error {
+		var err error
+		accounts, err = s.getAccounts(accountsCtx, token, amount)
+		return err
+	})
+	tracing.End(span, err)
 	if err != nil {
-		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("failed to get accounts: %w", err)
+		return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{Stage: payment.StageAccounts, Err: fmt.Errorf("failed to get accounts: %w", err)}
 	}
 
 	if len(accounts) == 0 {
 		logger.WarningLogger.Println("No accounts found.")
-		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("no accounts available")
+		return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{Stage: payment.StageAccounts, Err: fmt.Errorf("no accounts available")}
 	}
 
-	fmt.Println("Available bank accounts:")
-	for i, account := range accounts {
-		fmt.Printf("Account #%d:\n", i+1)
-		if logo, ok := account["logo"].(string); ok {
-			fmt.Printf("  Logo: %s\n", logo)
-		}
-		fmt.Printf("  _id: %s\n", account["_id"])
-		fmt.Printf("  Name: %s\n", account["name"])
-
-		if innerAccounts, ok := account["accounts"].([]interface{}); ok && len(innerAccounts) > 0 {
-			fmt.Printf("  Accounts: \n")
-			for _, innerAccount := range innerAccounts {
-				if innerMap, ok := innerAccount.(map[string]interface{}); ok {
-					fmt.Printf("    - ID: %s\n", innerMap["_id"])
-					if fields, ok := innerMap["fields"].([]interface{}); ok {
-						for _, field := range fields {
-							if fieldMap, ok := field.(map[string]interface{}); ok {
-								fmt.Printf("      %s: %s\n", fieldMap["name"], fieldMap["value"])
-							}
-						}
-					}
-				}
-			}
-		}
-
-		fmt.Println("---")
+	selectAccount := req.SelectAccount
+	if selectAccount == nil {
+		selectAccount = PromptAccount
 	}
-
-	fmt.Print("Enter the account number to use: ")
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	choice, err := strconv.Atoi(input[:len(input)-1])
-	if err != nil || choice < 1 || choice > len(accounts) {
+	choice, err := selectAccount(accounts)
+	if err != nil {
+		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("invalid selection: %w", err)
+	}
+	if choice < 0 || choice >= len(accounts) {
 		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("invalid selection")
 	}
 
-	selected := accounts[choice-1]
+	selected := accounts[choice]
 	accountList, ok := selected["accounts"].([]interface{})
 	if !ok || len(accountList) == 0 {
 		return payment.DepositResponse{}, models.PaymentModel{}, fmt.Errorf("no inner accounts found")
@@ -87,11 +84,6 @@ func (s *SansgetirsinAggregator) InteractiveDepositFlow(amount float64) (payment
 		"description": "Test deposit",
 	}
 
-	resp, err := s.MakeDepositWithData(token, bankID, amount, extraData)
-	if err != nil {
-		return payment.DepositResponse{}, models.PaymentModel{}, err
-	}
-
 	// Extract fields from selected accountMap
 	payerName := ""
 	iban := ""
@@ -113,19 +105,91 @@ func (s *SansgetirsinAggregator) InteractiveDepositFlow(amount float64) (payment
 		}
 	}
 
-	// Construct full payment model
+	// The payment about to be made, screened by the caller's checks before any money moves
 	paymentDoc := models.PaymentModel{
-		TransactionID:   resp.TransactionID,
-		Amount:          resp.Amount,
-		Status:          resp.Status,
-		TransactionType: "deposit",
+		MerchantID:      req.MerchantID,
+		Amount:          amount,
+		Status:          models.StatusPending, // confirmed once the payer's transfer arrives
+		TransactionType: models.TypeDeposit,
 		PayerName:       payerName,
-		Aggregator:      "Sans Getirsin",
+		PayerIBAN:       req.PayerIBAN,
+		Aggregator:      s.instanceName(),
 		IBAN:            iban,
 		BankName:        bankName,
-		CreatedAt:       primitive.NewDateTimeFromTime(time.Now()),
 	}
+	if req.Check != nil {
+		if err := req.Check(ctx, paymentDoc); err != nil {
+			return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{Stage: payment.StageChecks, Err: err}
+		}
+	}
+
+	// the payer may take a while to choose, withToken refreshes an expired session
+	var resp payment.DepositResponse
+	depositCtx, span := tracing.Start(ctx, "MakeDepositWithData", attrs...)
+	err = s.withToken(depositCtx, func(token string) error {
+		var err error
+		resp, err = s.makeDeposit(depositCtx, token, bankID, amount, extraData)
+		return err
+	})
+	span.SetAttributes(attribute.String("transaction_id", resp.TransactionID))
+	tracing.End(span, err)
+	if err != nil {
+		return payment.DepositResponse{}, models.PaymentModel{}, &payment.FlowError{Stage: payment.StageDeposit, Err: err}
+	}
+
+	// Construct full payment model
+	paymentDoc.TransactionID = resp.TransactionID
+	paymentDoc.Amount = resp.Amount
+	paymentDoc.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 
 	return resp, paymentDoc, nil
 
 }
+
+// instanceName falls back to the adapter name for aggregators built without an instance
+func (s *SansgetirsinAggregator) instanceName() string {
+	if s.Instance == "" {
+		return Name
+	}
+	return s.Instance
+}
+
+// PromptAccount prints the provider's accounts and reads the payer's choice from stdin
+func PromptAccount(accounts []map[string]interface{}) (int, error) {
+	fmt.Println("Available bank accounts:")
+	for i, account := range accounts {
+		fmt.Printf("Account #%d:\n", i+1)
+		if logo, ok := account["logo"].(string); ok {
+			fmt.Printf("  Logo: %s\n", logo)
+		}
+		fmt.Printf("  _id: %s\n", account["_id"])
+		fmt.Printf("  Name: %s\n", account["name"])
+
+		if innerAccounts, ok := account["accounts"].([]interface{}); ok && len(innerAccounts) > 0 {
+			fmt.Printf("  Accounts: \n")
+			for _, innerAccount := range innerAccounts {
+				if innerMap, ok := innerAccount.(map[string]interface{}); ok {
+					fmt.Printf("    - ID: %s\n", innerMap["_id"])
+					if fields, ok := innerMap["fields"].([]interface{}); ok {
+						for _, field := range fields {
+							if fieldMap, ok := field.(map[string]interface{}); ok {
+								fmt.Printf("      %s: %s\n", fieldMap["name"], fieldMap["value"])
+							}
+						}
+					}
+				}
+			}
+		}
+
+		fmt.Println("---")
+	}
+
+	fmt.Print("Enter the account number to use: ")
+	reader := bufio.NewReader(os.Stdin)
+	input, _ := reader.ReadString('\n')
+	choice, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || choice < 1 || choice > len(accounts) {
+		return 0, fmt.Errorf("choose an account between 1 and %d", len(accounts))
+	}
+	return choice - 1, nil
+}
@@ -0,0 +1,116 @@
+package sansgetirsin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-aggregator/internal/logger"
+	"payment-aggregator/internal/session"
+	"payment-aggregator/models"
+	"payment-aggregator/payment"
+	"strings"
+)
+
+var (
+	_ payment.StatusQuerier  = &SansgetirsinAggregator{}
+	_ payment.CallbackParser = &SansgetirsinAggregator{}
+)
+
+// GetTransactionStatus asks sansgetirsin for the current status of a transaction
+func (s *SansgetirsinAggregator) GetTransactionStatus(ctx context.Context, transactionID string) (payment.TransactionStatus, error) {
+	var status payment.TransactionStatus
+	err := s.withToken(ctx, func(token string) error {
+		var err error
+		status, err = s.getTransactionStatus(ctx, token, transactionID)
+		return err
+	})
+	return status, err
+}
+
+func (s *SansgetirsinAggregator) getTransactionStatus(ctx context.Context, token, transactionID string) (payment.TransactionStatus, error) {
+	logger.InfoLogger.Printf("Sansgetirsin: Getting status of transaction %s...", transactionID)
+
+	// Construct the request URL (adjust based on API docs)
+	statusURL := fmt.Sprintf("%s/payment/status?transactionId=%s", s.BaseURL, url.QueryEscape(transactionID))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", statusURL, nil)
+	if err != nil {
+		return payment.TransactionStatus{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	client := s.httpClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return payment.TransactionStatus{}, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return payment.TransactionStatus{}, fmt.Errorf("sansgetirsin status request: %w", session.ErrUnauthorized)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return payment.TransactionStatus{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	logger.InfoLogger.Printf("Sansgetirsin: Raw status response: %s", string(body))
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return payment.TransactionStatus{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if errorMsg, ok := response["error"].(string); ok {
+		return payment.TransactionStatus{}, fmt.Errorf("sansgetirsin API error: %s", errorMsg)
+	}
+
+	data, ok := response["data"].(map[string]interface{})
+	if !ok {
+		return payment.TransactionStatus{}, fmt.Errorf("data not found in response")
+	}
+
+	providerStatus, _ := data["status"].(string)
+	return payment.TransactionStatus{
+		TransactionID:  transactionID,
+		Status:         mapStatus(providerStatus),
+		ProviderStatus: providerStatus,
+	}, nil
+}
+
+// ParseCallback reads the transaction ID and status from a sansgetirsin callback body
+func (s *SansgetirsinAggregator) ParseCallback(body []byte) (payment.TransactionStatus, error) {
+	var callback struct {
+		TransactionID string `json:"transactionId"`
+		Status        string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return payment.TransactionStatus{}, fmt.Errorf("failed to unmarshal callback: %w", err)
+	}
+	if callback.TransactionID == "" {
+		return payment.TransactionStatus{}, fmt.Errorf("transactionId not found in callback")
+	}
+
+	return payment.TransactionStatus{
+		TransactionID:  callback.TransactionID,
+		Status:         mapStatus(callback.Status),
+		ProviderStatus: callback.Status,
+	}, nil
+}
+
+// mapStatus translates sansgetirsin statuses to ours, anything unknown stays pending
+func mapStatus(providerStatus string) string {
+	switch strings.ToLower(providerStatus) {
+	case "approved", "completed", "success", "confirmed":
+		return models.StatusConfirmed
+	case "rejected", "cancelled", "canceled", "failed", "declined":
+		return models.StatusFailed
+	default:
+		return models.StatusPending
+	}
+}
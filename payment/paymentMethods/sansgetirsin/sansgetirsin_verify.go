@@ -0,0 +1,38 @@
+package sansgetirsin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"payment-aggregator/payment"
+)
+
+var _ payment.CallbackVerifier = &SansgetirsinAggregator{}
+
+// SignatureHeader carries the hex HMAC-SHA256 of the callback body, keyed with the instance's callback secret
+const SignatureHeader = "X-Signature"
+
+// VerifyCallback checks the callback's signature against <PREFIX>_CALLBACK_SECRET.
+// Without a secret no callback can be trusted, so all of them are refused
+func (s *SansgetirsinAggregator) VerifyCallback(headers http.Header, body []byte) error {
+	if s.CallbackSecret == "" {
+		return errors.New("no callback secret configured")
+	}
+	signature, err := hex.DecodeString(headers.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return errors.New("missing or malformed " + SignatureHeader + " header")
+	}
+	if !hmac.Equal(signature, Sign(s.CallbackSecret, body)) {
+		return errors.New("callback signature does not match")
+	}
+	return nil
+}
+
+// Sign computes the signature the provider sends with a callback body
+func Sign(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,39 @@
+package sansgetirsin
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestVerifyCallback(t *testing.T) {
+	body := []byte(`{"transactionId":"tx-1","status":"approved"}`)
+	signed := hex.EncodeToString(Sign("secret", body))
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		body      []byte
+		wantErr   bool
+	}{
+		{"valid signature", "secret", signed, body, false},
+		{"no secret configured", "", signed, body, true},
+		{"missing signature", "secret", "", body, true},
+		{"malformed signature", "secret", "not-hex", body, true},
+		{"signed with another secret", "other", signed, body, true},
+		{"body changed", "secret", signed, []byte(`{"transactionId":"tx-1","status":"rejected"}`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SansgetirsinAggregator{CallbackSecret: tt.secret}
+			headers := http.Header{}
+			if tt.signature != "" {
+				headers.Set(SignatureHeader, tt.signature)
+			}
+			if err := s.VerifyCallback(headers, tt.body); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package payment
+
+import "payment-aggregator/models"
+
+type RefundResponse struct {
+	Status        string `json:"status"` // models.StatusConfirmed or models.StatusPending
+	TransactionID string `json:"transactionId"`
+	Message       string `json:"message,omitempty"`
+}
+
+// Refunder can optionally be implemented by adapters whose provider supports refunds.
+// Refunds on other aggregators fall back to a manual payout
+type Refunder interface {
+	Refund(original models.PaymentModel, amount float64) (RefundResponse, error)
+}
@@ -0,0 +1,90 @@
+package payment
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Capability is something an aggregator adapter declares it can do
+type Capability string
+
+const (
+	CapabilityDeposit     Capability = "deposit"
+	CapabilityWithdrawal  Capability = "withdrawal"
+	CapabilityRefund      Capability = "refund"
+	CapabilityStatusQuery Capability = "status_query"
+	CapabilityCallbacks   Capability = "callbacks"
+)
+
+// ConfigField describes one setting an adapter reads,
+// Name is the key after the instance prefix (e.g. USERNAME for SANSGETIRSIN_USERNAME)
+type ConfigField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+}
+
+// Descriptor is what an adapter registers about itself
+type Descriptor struct {
+	Name         string        `json:"name"`
+	DisplayName  string        `json:"display_name"`
+	Config       []ConfigField `json:"config"`
+	Capabilities []Capability  `json:"capabilities"`
+	Currencies   []string      `json:"currencies"`
+
+	// New builds a runner for one configured instance of the adapter
+	New func(instance InstanceConfig) FlowRunner `json:"-"`
+}
+
+// Supports reports whether the adapter declared the capability
+func (d Descriptor) Supports(c Capability) bool {
+	for _, capability := range d.Capabilities {
+		if capability == c {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Descriptor{}
+)
+
+// Register adds an adapter to the registry, adapters call it from init
+func Register(d Descriptor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if d.Name == "" || d.New == nil {
+		panic("payment: Register needs a name and a constructor")
+	}
+	if _, exists := registry[d.Name]; exists {
+		panic(fmt.Sprintf("payment: aggregator %q registered twice", d.Name))
+	}
+	registry[d.Name] = d
+}
+
+// Lookup returns the descriptor registered under name
+func Lookup(name string) (Descriptor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// Registered returns every registered adapter, sorted by name
+func Registered() []Descriptor {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	descriptors := make([]Descriptor, 0, len(registry))
+	for _, d := range registry {
+		descriptors = append(descriptors, d)
+	}
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].Name < descriptors[j].Name })
+	return descriptors
+}
@@ -0,0 +1,30 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+)
+
+// TransactionStatus is a provider's view of a transaction, mapped to a models status
+type TransactionStatus struct {
+	TransactionID  string `json:"transactionId"`
+	Status         string `json:"status"`         // models.StatusPending, StatusConfirmed or StatusFailed
+	ProviderStatus string `json:"providerStatus"` // as sent by the provider
+}
+
+// StatusQuerier can optionally be implemented by adapters whose provider
+// lets us ask for a transaction's status, used when callbacks don't arrive
+type StatusQuerier interface {
+	GetTransactionStatus(ctx context.Context, transactionID string) (TransactionStatus, error)
+}
+
+// CallbackParser can optionally be implemented by adapters that receive callbacks
+type CallbackParser interface {
+	ParseCallback(body []byte) (TransactionStatus, error)
+}
+
+// CallbackVerifier can optionally be implemented by adapters whose provider
+// signs its callbacks, so forged ones are rejected before they are applied
+type CallbackVerifier interface {
+	VerifyCallback(headers http.Header, body []byte) error
+}